@@ -1,266 +1,148 @@
+// Command app runs the ThreatFabric telemetry collector: it serves the
+// TelemetryPacket collector over both gRPC and HTTP (plus OTLP ingest),
+// backed by the auth/rate-limit/TLS middleware in internal/middleware and an
+// Elasticsearch bulk indexer. This supersedes the collector's original
+// MetricBatch/LogBatch service (internal/proto) and internal/server's
+// standalone Service - both were superseded by the httpserver/grpcserver
+// pair below during this package's development and are no longer wired in.
 package main
 
 import (
-	"bytes"
 	"context"
-	"encoding/hex"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
-	"github.com/elastic/go-elasticsearch/v8"
-	"github.com/elastic/go-elasticsearch/v8/esutil"
-	"github.com/spf13/viper"
 	"go.uber.org/zap"
-	"google.golang.org/grpc"
-	"google.golang.org/protobuf/encoding/protojson"
-	"google.golang.org/protobuf/proto"
 
-	pb "github.com/threatfabric-devops/tf-telemetry/internal/proto"
+	"github.com/threatfabric-devops/tf-telemetry/internal/config"
+	"github.com/threatfabric-devops/tf-telemetry/internal/grpcserver"
+	"github.com/threatfabric-devops/tf-telemetry/internal/healthz"
+	"github.com/threatfabric-devops/tf-telemetry/internal/httpserver"
+	"github.com/threatfabric-devops/tf-telemetry/internal/indexer"
+	"github.com/threatfabric-devops/tf-telemetry/internal/ingest"
+	"github.com/threatfabric-devops/tf-telemetry/internal/logger"
+	"github.com/threatfabric-devops/tf-telemetry/internal/middleware"
 )
 
-// --- Config ---
-type Config struct {
-	Server struct {
-		GrpcPort int `mapstructure:"grpc_port"`
-		HttpPort int `mapstructure:"http_port"`
-	} `mapstructure:"server"`
-	Elastic struct {
-		Addresses     []string `mapstructure:"addresses"`
-		Username      string   `mapstructure:"username"`
-		Password      string   `mapstructure:"password"`
-		IndexMetrics  string   `mapstructure:"index_metrics"`
-		IndexLogs     string   `mapstructure:"index_logs"`
-		BatchSize     int      `mapstructure:"batch_size"`
-		FlushInterval int      `mapstructure:"flush_interval_seconds"`
-	} `mapstructure:"elasticsearch"`
-	Logging struct {
-		Level string `mapstructure:"level"`
-	} `mapstructure:"logging"`
-}
-
-// --- Service ---
-type Server struct {
-	pb.UnimplementedTelemetryServiceServer
-	logger      *zap.Logger
-	bulkIndexer esutil.BulkIndexer
-	cfg         *Config
-}
+// shutdownTimeout bounds how long graceful shutdown waits for in-flight
+// requests and the bulk indexer's final flush before main returns anyway.
+const shutdownTimeout = 15 * time.Second
 
 func main() {
-	// 1. Config
-	viper.SetConfigFile("config.yaml")
-	if err := viper.ReadInConfig(); err != nil {
-		panic(fmt.Errorf("fatal error config file: %w", err))
-	}
-	var cfg Config
-	if err := viper.Unmarshal(&cfg); err != nil {
-		panic(err)
-	}
-
-	// 2. Logger
-	loggerConfig := zap.NewProductionConfig()
-	if err := loggerConfig.Level.UnmarshalText([]byte(cfg.Logging.Level)); err != nil {
-		panic(fmt.Errorf("invalid log level: %w", err))
-	}
-	logger, err := loggerConfig.Build()
+	cfg, err := config.Load("config.yaml")
 	if err != nil {
-		panic(fmt.Errorf("failed to build logger: %w", err))
+		fmt.Fprintf(os.Stderr, "load config: %v\n", err)
+		os.Exit(1)
 	}
-	defer logger.Sync()
 
-	// 3. Elasticsearch Client
-	es, err := elasticsearch.NewClient(elasticsearch.Config{
-		Addresses: cfg.Elastic.Addresses,
-		Username:  cfg.Elastic.Username,
-		Password:  cfg.Elastic.Password,
+	log, err := logger.NewWithConfig(logger.Config{
+		Level:            cfg.Logging.Level,
+		Encoding:         cfg.Logging.Encoding,
+		OutputPaths:      cfg.Logging.OutputPaths,
+		ErrorOutputPaths: cfg.Logging.ErrorOutputPaths,
 	})
 	if err != nil {
-		logger.Fatal("Error creating ES client", zap.Error(err))
+		fmt.Fprintf(os.Stderr, "build logger: %v\n", err)
+		os.Exit(1)
 	}
+	defer log.Sync()
 
-	// 4. Bulk Indexer (Async Buffer)
-	bi, err := esutil.NewBulkIndexer(esutil.BulkIndexerConfig{
-		Index:         "", // defined per item
-		Client:        es,
-		NumWorkers:    2,
-		FlushBytes:    1024 * 1024, // 1MB
-		FlushInterval: time.Duration(cfg.Elastic.FlushInterval) * time.Second,
-	})
+	_, bulkIndexer, err := indexer.New(cfg, log)
 	if err != nil {
-		logger.Fatal("Error creating bulk indexer", zap.Error(err))
-	}
-
-	srv := &Server{
-		logger:      logger,
-		bulkIndexer: bi,
-		cfg:         &cfg,
+		log.Fatal("failed to initialize Elasticsearch indexer", zap.Error(err))
 	}
 
-	// 5. Run Servers
-	go runGrpc(srv, cfg.Server.GrpcPort)
-	go runHttp(srv, cfg.Server.HttpPort)
-
-	// 6. Graceful Shutdown
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
-	<-stop
-
-	logger.Info("Shutting down...")
-	if err := bi.Close(context.Background()); err != nil {
-		logger.Error("Error closing bulk indexer", zap.Error(err))
-	}
-	logger.Info("Shutdown complete")
-}
-
-// --- gRPC Implementation ---
-
-func (s *Server) SubmitMetrics(ctx context.Context, batch *pb.MetricBatch) (*pb.Ack, error) {
-	s.logger.Debug("Received metrics batch", zap.Int("count", len(batch.Metrics)))
-
-	// Flatten batch to individual ES documents
-	for _, point := range batch.Metrics {
-		doc := map[string]interface{}{
-			"timestamp":       point.ClientTimestampMs, // Map to @timestamp in ES
-			"schema_version":  batch.SchemaVersion,
-			"platform":        batch.Platform.String(),
-			"customer_id":     batch.CustomerId,
-			"sdk_version":     batch.SdkVersionPacked,
-			"app_version":     batch.HostAppVersion,
-			"installation_id": hex.EncodeToString(batch.InstallationId), // Bytes -> Hex
-			"journey_id":      hex.EncodeToString(batch.JourneyId),      // Bytes -> Hex
-			"network":         point.NetworkType.String(),
-			"battery_level":   point.BatteryLevelPercent,
-			"cpu_usage":       point.CpuUsagePercent,
-			"memory_usage_mb": point.MemoryUsageMb,
-		}
-		s.indexAsync(s.cfg.Elastic.IndexMetrics, doc)
+	sender, err := ingest.NewSender(log, bulkIndexer, cfg)
+	if err != nil {
+		log.Fatal("failed to initialize telemetry sender", zap.Error(err))
 	}
+	defer sender.Close()
 
-	return &pb.Ack{Success: true, Message: "Accepted"}, nil
-}
-
-func (s *Server) SubmitLogs(ctx context.Context, batch *pb.LogBatch) (*pb.Ack, error) {
-	s.logger.Debug("Received logs batch", zap.Int("count", len(batch.Logs)))
+	hc := healthz.New()
+	defer hc.Close()
+	sender.RegisterHealthChecks(hc)
 
-	for _, log := range batch.Logs {
-		doc := map[string]interface{}{
-			"timestamp":       log.ClientTimestampMs,
-			"platform":        batch.Platform.String(),
-			"customer_id":     batch.CustomerId,
-			"app_version":     batch.HostAppVersion,
-			"installation_id": hex.EncodeToString(batch.InstallationId),
-			"journey_id":      hex.EncodeToString(batch.JourneyId),
-			"level":           log.Level.String(),
-			"tag":             log.Tag,
-			"message":         log.Message,
-			"thread":          log.ThreadName,
-			"stack_trace":     log.StackTrace,
-			"error_code":      log.ErrorCode,
-			"attributes":      log.Attributes,
-		}
-		s.indexAsync(s.cfg.Elastic.IndexLogs, doc)
-	}
+	limiter := middleware.NewRateLimiter(cfg.Server.RateLimit)
+	defer limiter.Close()
 
-	return &pb.Ack{Success: true, Message: "Accepted"}, nil
-}
-
-// --- Helper: Async ES Write ---
-func (s *Server) indexAsync(index string, doc interface{}) {
-	data, err := json.Marshal(doc)
+	grpcServer, err := grpcserver.New(cfg, httpserver.NewService(sender), limiter)
 	if err != nil {
-		s.logger.Error("Failed to marshal document", zap.Error(err))
-		return
+		log.Fatal("failed to initialize gRPC server", zap.Error(err))
 	}
-
-	err = s.bulkIndexer.Add(context.Background(), esutil.BulkIndexerItem{
-		Action: "index",
-		Index:  index,
-		Body:   bytes.NewReader(data),
-		OnFailure: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
-			if err != nil {
-				s.logger.Error("Failed to index", zap.Error(err))
-				return
-			}
-			if res.Error.Type != "" || res.Error.Reason != "" {
-				s.logger.Error("Failed to index", zap.String("err", res.Error.Reason))
-				return
-			}
-			s.logger.Error("Failed to index")
-		},
-	})
+	lis, addr, err := grpcserver.Listen(cfg)
 	if err != nil {
-		s.logger.Error("Failed to add to indexer", zap.Error(err))
+		log.Fatal("failed to open gRPC listener", zap.Error(err))
 	}
-}
+	go func() {
+		log.Info("gRPC server listening", zap.String("addr", addr))
+		if err := grpcServer.Serve(lis); err != nil {
+			log.Error("gRPC server stopped", zap.Error(err))
+		}
+	}()
 
-func decodeRequestBody(r *http.Request, message proto.Message) error {
-	defer r.Body.Close()
-	body, err := io.ReadAll(r.Body)
+	httpSrv, err := httpserver.New(cfg, sender, limiter, hc)
 	if err != nil {
-		return err
+		log.Fatal("failed to initialize HTTP server", zap.Error(err))
 	}
-	return protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(body, message)
-}
+	go serveHTTP(log, httpSrv)
 
-// --- Server Launchers ---
-
-func runGrpc(srv *Server, port int) {
-	lis, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	// Only non-nil when Server.TLS's certificate is ACME-provisioned; it
+	// answers ACME's HTTP-01 challenge on :80 alongside httpSrv's TLS
+	// listener.
+	acmeSrv, err := httpserver.ACMEChallengeServer(cfg)
 	if err != nil {
-		srv.logger.Fatal("Failed to listen gRPC", zap.Error(err))
+		log.Fatal("failed to initialize ACME challenge server", zap.Error(err))
 	}
-	grpcServer := grpc.NewServer()
-	pb.RegisterTelemetryServiceServer(grpcServer, srv)
-	srv.logger.Info("gRPC server listening", zap.Int("port", port))
-	if err := grpcServer.Serve(lis); err != nil {
-		srv.logger.Fatal("Failed to serve gRPC", zap.Error(err))
+	if acmeSrv != nil {
+		go func() {
+			log.Info("ACME challenge server listening", zap.String("addr", acmeSrv.Addr))
+			if err := acmeSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Error("ACME challenge server stopped", zap.Error(err))
+			}
+		}()
 	}
-}
 
-func runHttp(srv *Server, port int) {
-	mux := http.NewServeMux()
-
-	// Helper to handle JSON -> Proto -> Service logic
-	mux.HandleFunc("/v1/metrics", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-		var batch pb.MetricBatch
-		if err := decodeRequestBody(r, &batch); err != nil {
-			http.Error(w, "Invalid JSON", http.StatusBadRequest)
-			return
-		}
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+	log.Info("shutting down")
 
-		_, _ = srv.SubmitMetrics(r.Context(), &batch)
-		w.WriteHeader(http.StatusAccepted)
-		w.Write([]byte(`{"status":"accepted"}`))
-	})
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
 
-	mux.HandleFunc("/v1/logs", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-			return
-		}
-		var batch pb.LogBatch
-		if err := decodeRequestBody(r, &batch); err != nil {
-			http.Error(w, "Invalid JSON", http.StatusBadRequest)
-			return
+	if err := httpSrv.Shutdown(ctx); err != nil {
+		log.Error("HTTP server shutdown", zap.Error(err))
+	}
+	if acmeSrv != nil {
+		if err := acmeSrv.Shutdown(ctx); err != nil {
+			log.Error("ACME challenge server shutdown", zap.Error(err))
 		}
-		_, _ = srv.SubmitLogs(r.Context(), &batch)
-		w.WriteHeader(http.StatusAccepted)
-		w.Write([]byte(`{"status":"accepted"}`))
-	})
+	}
+	grpcServer.GracefulStop()
+	if err := bulkIndexer.Close(ctx); err != nil {
+		log.Error("bulk indexer shutdown", zap.Error(err))
+	}
+	log.Info("shutdown complete")
+}
 
-	srv.logger.Info("HTTP server listening", zap.Int("port", port))
-	if err := http.ListenAndServe(fmt.Sprintf(":%d", port), mux); err != nil {
-		srv.logger.Fatal("Failed to serve HTTP", zap.Error(err))
+// serveHTTP runs srv, choosing TLS or plaintext based on whether New already
+// populated srv.TLSConfig (empty cert/key arguments tell ListenAndServeTLS to
+// use that TLSConfig's GetCertificate/Certificates instead of loading files
+// itself).
+func serveHTTP(log *zap.Logger, srv *http.Server) {
+	log.Info("HTTP server listening", zap.String("addr", srv.Addr), zap.Bool("tls", srv.TLSConfig != nil))
+	var err error
+	if srv.TLSConfig != nil {
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Error("HTTP server stopped", zap.Error(err))
 	}
 }
@@ -2,8 +2,10 @@
 package config
 
 import (
+	"encoding/hex"
 	"fmt"
 	"math"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -14,28 +16,486 @@ type BasicAuthConfig struct {
 	Password string `mapstructure:"password"`
 }
 
+// TenantRateLimitConfig overrides the default requests-per-second/burst for
+// a single tenant ID, keyed by RateLimitConfig.Tenants.
+type TenantRateLimitConfig struct {
+	RequestsPerSecond float64 `mapstructure:"requests_per_second"`
+	Burst             int     `mapstructure:"burst"`
+}
+
 type RateLimitConfig struct {
 	Enabled           bool    `mapstructure:"enabled"`
 	RequestsPerSecond float64 `mapstructure:"requests_per_second"`
 	Burst             int     `mapstructure:"burst"`
+	// TrustedProxyCIDRs lists the subnets allowed to set X-Forwarded-For/
+	// Forwarded headers. No code in this module reads this list: neither
+	// header is parsed, and rate-limit keys never come from the peer address.
+	TrustedProxyCIDRs []string `mapstructure:"trusted_proxy_cidrs"`
+	// Tenants overrides the default limit/burst for specific tenant IDs,
+	// matched against the authenticated principal's tenant (falling back to
+	// its subject) rather than the default requests_per_second/burst. A key
+	// need not name a whole ID: if no exact key matches, the longest key that
+	// is a prefix of the lookup key wins instead, so e.g. "abuse-" can throttle
+	// every installation ID starting with that prefix without enumerating them.
+	Tenants map[string]TenantRateLimitConfig `mapstructure:"tenants"`
+	// IdleTTLSeconds bounds how long a per-key limiter may sit unused before
+	// the janitor evicts it. Zero disables eviction.
+	IdleTTLSeconds int `mapstructure:"idle_ttl_seconds"`
+	// MaxKeys caps the total number of per-key limiters tracked at once,
+	// evicting the least recently used key once the cap is reached. Zero
+	// falls back to a default of 100k.
+	MaxKeys int `mapstructure:"max_keys"`
+	// Adaptive scales the per-key rate down under process-wide overload.
+	Adaptive AdaptiveRateLimitConfig `mapstructure:"adaptive"`
+	// Penalty escalates the cool-down for a key that keeps getting rejected,
+	// on top of the plain GCRA wait.
+	Penalty PenaltyConfig `mapstructure:"penalty"`
+}
+
+// PenaltyConfig configures RateLimiter's escalating cool-down: once a key
+// accumulates at least Threshold consecutive rejections, each further
+// rejection sets a cool-down of BaseSeconds*2^n (n counted from the
+// threshold), capped at MaxSeconds, during which the key is rejected
+// outright without even evaluating the GCRA - so a client that retries
+// exactly as fast as the bucket recovers is still pushed back, instead of
+// converging on a steady stream of rejections at the plain per-call wait.
+type PenaltyConfig struct {
+	Enabled     bool `mapstructure:"enabled"`
+	Threshold   int  `mapstructure:"threshold"`
+	BaseSeconds int  `mapstructure:"base_seconds"`
+	MaxSeconds  int  `mapstructure:"max_seconds"`
+}
+
+// AdaptiveRateLimitConfig lets the rate limiter trade throughput for
+// stability under load: once the number of requests the limiter is
+// concurrently gating exceeds InflightHighWatermark, every per-key limit is
+// cut by DecreaseFactor (multiplicative decrease); once inflight drops back
+// below the watermark, the limit recovers by RecoveryStep per adjustment
+// tick (additive increase) back up to the configured RequestsPerSecond.
+type AdaptiveRateLimitConfig struct {
+	Enabled               bool    `mapstructure:"enabled"`
+	InflightHighWatermark int64   `mapstructure:"inflight_high_watermark"`
+	MinRateFraction       float64 `mapstructure:"min_rate_fraction"`
+	DecreaseFactor        float64 `mapstructure:"decrease_factor"`
+	RecoveryStep          float64 `mapstructure:"recovery_step"`
+}
+
+// MTLSConfig enables mutual TLS on the HTTP/gRPC listeners. When
+// AllowedCommonNames or AllowedSANs is non-empty, a verified client
+// certificate is only accepted if its CN appears in AllowedCommonNames, or
+// one of its DNS/URI (e.g. SPIFFE ID) SANs appears in AllowedSANs; an empty
+// list accepts any CA-verified certificate. RequireSAN additionally rejects
+// certificates that present no SAN at all, since modern TLS clients ignore
+// CN for identity and a CN-only certificate likely predates that policy.
+type MTLSConfig struct {
+	Enabled            bool     `mapstructure:"enabled"`
+	ClientCAFile       string   `mapstructure:"client_ca_file"`
+	AllowedCommonNames []string `mapstructure:"allowed_common_names"`
+	AllowedSANs        []string `mapstructure:"allowed_sans"`
+	RequireSAN         bool     `mapstructure:"require_san"`
+}
+
+// TLSConfig enables httpserver/grpcserver to terminate TLS directly instead
+// of requiring a TLS-terminating proxy in front of them. Exactly one of
+// (CertFile, KeyFile) or ACME should be set to provide the server's own
+// certificate; CertFile/KeyFile is checked first, so ACME is only consulted
+// when no static pair is configured. MTLSConfig is layered on top
+// independently: it governs whether/how a client certificate is required,
+// not the server's own identity, so it applies the same way whether the
+// server's certificate came from CertFile/KeyFile or ACME.
+type TLSConfig struct {
+	Enabled  bool       `mapstructure:"enabled"`
+	CertFile string     `mapstructure:"cert_file"`
+	KeyFile  string     `mapstructure:"key_file"`
+	ACME     ACMEConfig `mapstructure:"acme"`
+}
+
+// ACMEConfig automatically provisions and renews the server certificate via
+// an ACME CA (e.g. Let's Encrypt) using golang.org/x/crypto/acme/autocert.
+// Hosts restricts issuance to those hostnames (autocert's HostPolicy, to stop
+// an attacker from requesting certificates for arbitrary names through this
+// server); CacheDir persists issued certificates across restarts so renewals
+// don't re-hit the CA's rate limits on every redeploy. The HTTP-01 challenge
+// autocert needs is served on port 80 by httpserver.ACMEChallengeServer,
+// independent of Server.HTTPPort.
+type ACMEConfig struct {
+	Enabled  bool     `mapstructure:"enabled"`
+	CacheDir string   `mapstructure:"cache_dir"`
+	Hosts    []string `mapstructure:"hosts"`
+	Email    string   `mapstructure:"email"`
+}
+
+// JWTConfig validates bearer tokens against keys published at JWKSURL,
+// modeled after the etcd auth JWT verifier: keys are cached and refreshed
+// periodically rather than fetched per request.
+type JWTConfig struct {
+	Enabled                bool   `mapstructure:"enabled"`
+	JWKSURL                string `mapstructure:"jwks_url"`
+	Issuer                 string `mapstructure:"issuer"`
+	Audience               string `mapstructure:"audience"`
+	RefreshIntervalSeconds int    `mapstructure:"refresh_interval_seconds"`
+	ClockSkewSeconds       int    `mapstructure:"clock_skew_seconds"`
+}
+
+// CORSConfig controls the CORS headers CorsMiddleware adds to HTTP
+// responses. AllowedOrigins entries may be an exact origin, "*", or a glob
+// pattern such as "https://*.threatfabric.com" (compiled once by
+// NewCORSPolicy); AllowCredentials, if set, forbids "*" and echoes the
+// request's Origin instead, per the Fetch spec's credentialed-request rule.
+// MaxAge controls how long a browser may cache a preflight response before
+// re-checking it.
+type CORSConfig struct {
+	Enabled          bool          `mapstructure:"enabled"`
+	AllowedOrigins   []string      `mapstructure:"allowed_origins"`
+	AllowedMethods   []string      `mapstructure:"allowed_methods"`
+	AllowedHeaders   []string      `mapstructure:"allowed_headers"`
+	ExposedHeaders   []string      `mapstructure:"exposed_headers"`
+	AllowCredentials bool          `mapstructure:"allow_credentials"`
+	MaxAge           time.Duration `mapstructure:"max_age"`
+}
+
+// CompressionConfig controls CompressionHTTP: responses at or above MinSize
+// bytes are compressed with whichever of EnabledEncodings the client's
+// Accept-Encoding prefers, and request bodies carrying a matching
+// Content-Encoding are transparently decompressed before they reach the
+// protobuf decoder. MaxDecompressedBytes bounds the latter so a small
+// compressed body can't expand into a zip-bomb-sized allocation.
+type CompressionConfig struct {
+	Enabled              bool     `mapstructure:"enabled"`
+	MinSize              int      `mapstructure:"min_size"`
+	Level                int      `mapstructure:"level"`
+	MaxDecompressedBytes int64    `mapstructure:"max_decompressed_bytes"`
+	EnabledEncodings     []string `mapstructure:"enabled_encodings"`
+}
+
+// HMACAuthConfig lets an on-device agent authenticate ingest requests by
+// signing them with a per-installation secret instead of shipping a shared
+// Basic Auth password in the binary. SkewSeconds bounds how far a request's
+// timestamp may drift from the server's clock; NonceCacheSize bounds the
+// replay-detection cache. Secrets maps InstallationId to its hex-encoded
+// shared secret directly; SecretsFile, if set, loads the same mapping from
+// a JSON file instead so secrets can be rotated without a config redeploy.
+type HMACAuthConfig struct {
+	Enabled        bool              `mapstructure:"enabled"`
+	SkewSeconds    int               `mapstructure:"skew_seconds"`
+	NonceCacheSize int               `mapstructure:"nonce_cache_size"`
+	Secrets        map[string]string `mapstructure:"secrets"`
+	SecretsFile    string            `mapstructure:"secrets_file"`
+}
+
+// JWTAuthConfig configures JWTAuthHTTP/JWTAuthUnary's signature and claim
+// verification via github.com/golang-jwt/jwt/v5. HMACSecret selects
+// HS256/HS384/HS512; RSAPublicKey/ECPublicKey (PEM-encoded) or JWKSURL
+// selects RS256/ES256 - more than one may be set at once, in which case the
+// token's own alg header picks which key material applies. InstallationIDClaim
+// names the custom claim (defaulting to "installation_id") RateLimitUnary
+// keys off in preference to the packet's self-reported installation ID.
+// CustomerIDClaim names the custom claim (defaulting to "customer_id") that,
+// when present, takes precedence over InstallationIDClaim as that key.
+type JWTAuthConfig struct {
+	Enabled             bool   `mapstructure:"enabled"`
+	HMACSecret          string `mapstructure:"hmac_secret"`
+	RSAPublicKey        string `mapstructure:"rsa_public_key"`
+	ECPublicKey         string `mapstructure:"ec_public_key"`
+	JWKSURL             string `mapstructure:"jwks_url"`
+	Issuer              string `mapstructure:"issuer"`
+	Audience            string `mapstructure:"audience"`
+	ClockSkewSeconds    int    `mapstructure:"clock_skew_seconds"`
+	InstallationIDClaim string `mapstructure:"installation_id_claim"`
+	CustomerIDClaim     string `mapstructure:"customer_id_claim"`
+}
+
+// AuthConfig selects which of BasicAuth/JWTAuth guard the telemetry API.
+// Mode is "basic", "jwt", or "both" (the default when unset); when both are
+// enabled and Mode is "both", Precedence orders the any-of auth attempts
+// ComposeAuthHTTP/ComposeAuthUnary try, defaulting to ["jwt", "basic"].
+// PerRoute overrides that server-wide chain for specific HTTP routes, naming
+// an ordered subset of "mtls", "jwt", "basic" the named route alone
+// requires; it has no effect on the gRPC side, which exposes a single
+// service surface and so can only apply the server-wide chain. PerRoute is
+// keyed by the exact path registered on the mux, e.g. "/v1/telemetry".
+type AuthConfig struct {
+	Mode       string              `mapstructure:"mode"`
+	Precedence []string            `mapstructure:"precedence"`
+	PerRoute   map[string][]string `mapstructure:"per_route"`
+}
+
+// Includes reports whether scheme ("basic" or "jwt") is enabled under cfg's Mode.
+func (cfg AuthConfig) Includes(scheme string) bool {
+	if cfg.Mode == "" || cfg.Mode == "both" {
+		return true
+	}
+	return cfg.Mode == scheme
+}
+
+// OrderedSchemes returns the schemes Includes allows, in the order the any-of
+// auth chain should try them.
+func (cfg AuthConfig) OrderedSchemes() []string {
+	order := cfg.Precedence
+	if len(order) == 0 {
+		order = []string{"jwt", "basic"}
+	}
+	schemes := make([]string, 0, len(order))
+	for _, name := range order {
+		if cfg.Includes(name) {
+			schemes = append(schemes, name)
+		}
+	}
+	return schemes
+}
+
+// TokenAuthConfig holds settings for a self-issued access/refresh token
+// pair: Algorithm picks HS256 (SigningKey is the HMAC secret) or RS256
+// (SigningKey is a PEM private key), JWKSFile names the file of matching
+// public keys, and the TTL fields bound token lifetimes. No code in this
+// module reads this block: there is no /v1/auth/login or /v1/auth/refresh
+// endpoint, and no tokens are minted.
+type TokenAuthConfig struct {
+	Enabled          bool   `mapstructure:"enabled"`
+	Issuer           string `mapstructure:"issuer"`
+	Audience         string `mapstructure:"audience"`
+	Algorithm        string `mapstructure:"algorithm"`
+	SigningKey       string `mapstructure:"signing_key"`
+	JWKSFile         string `mapstructure:"jwks_file"`
+	AccessTTLMinutes int    `mapstructure:"access_ttl_minutes"`
+	RefreshTTLHours  int    `mapstructure:"refresh_ttl_hours"`
+}
+
+// BackpressureConfig holds settings for bounding the work handed off from
+// SendTelemetry to the bulk indexer: MaxInflight documents in flight at
+// once, EnqueueTimeoutMs to wait for a free slot, IndexTimeoutMs per
+// bulkIndexer.Add call. No code in this module reads this block:
+// SendTelemetry does not count in-flight documents, wait for a slot, drop
+// low-priority documents or time-bound bulkIndexer.Add. Its only
+// codes.ResourceExhausted failure is a full durable queue.
+type BackpressureConfig struct {
+	Enabled          bool `mapstructure:"enabled"`
+	MaxInflight      int  `mapstructure:"max_inflight"`
+	EnqueueTimeoutMs int  `mapstructure:"enqueue_timeout_ms"`
+	IndexTimeoutMs   int  `mapstructure:"index_timeout_ms"`
+}
+
+// OAuthConfig holds settings for a resource-server mode in which bearer
+// tokens from one of Issuers are checked for signature, audience and
+// RequiredScopes against JWKS discovered from each issuer's OIDC
+// configuration document, re-fetched every CacheTTLSeconds. No code in this
+// module reads this block; no OIDC discovery or scope check is performed.
+type OAuthConfig struct {
+	Enabled            bool     `mapstructure:"enabled"`
+	Issuers            []string `mapstructure:"issuers"`
+	Audience           string   `mapstructure:"audience"`
+	RequiredScopes     []string `mapstructure:"required_scopes"`
+	CacheTTLSeconds    int      `mapstructure:"cache_ttl_seconds"`
+	HTTPTimeoutSeconds int      `mapstructure:"http_timeout_seconds"`
+}
+
+// ObservabilityConfig gates the /metrics and /debug/pprof/* endpoints, and
+// configures OpenTelemetry tracing via Tracing. The /metrics and pprof
+// endpoints are guarded by their own Basic Auth credentials, independent of
+// the telemetry API's auth chain, since operators scraping metrics rarely
+// hold the same credentials as telemetry clients.
+type ObservabilityConfig struct {
+	Enabled   bool            `mapstructure:"enabled"`
+	BasicAuth BasicAuthConfig `mapstructure:"basic_auth"`
+	Tracing   TracingConfig   `mapstructure:"tracing"`
+}
+
+// TracingConfig configures the OpenTelemetry trace provider middleware.TracingUnary
+// and middleware.TracingHTTP install. Exporter selects where spans are sent -
+// "stdout" (for local debugging), "otlp/grpc", "otlp/http", or "none"/""
+// (the default, which disables tracing entirely). SampleRatio is the
+// fraction of traces sampled (0 samples none, 1 samples every trace).
+// ResourceAttributes are attached to the OTel Resource describing this
+// process (e.g. "deployment.environment": "prod"). Prometheus metrics
+// (MetricsUnary, and /metrics via ObservabilityConfig.Enabled) are exported
+// independently of this block, for operators who prefer scraping to OTLP.
+type TracingConfig struct {
+	Exporter           string            `mapstructure:"exporter"`
+	OTLPEndpoint       string            `mapstructure:"otlp_endpoint"`
+	SampleRatio        float64           `mapstructure:"sample_ratio"`
+	ResourceAttributes map[string]string `mapstructure:"resource_attributes"`
+}
+
+// Enabled reports whether cfg selects a real exporter; "none", "", and the
+// zero value all mean tracing is off.
+func (cfg TracingConfig) Enabled() bool {
+	return cfg.Exporter != "" && cfg.Exporter != "none"
+}
+
+// ILMPolicyConfig configures the hot/warm/delete phases applied to each
+// managed data stream's index lifecycle policy.
+type ILMPolicyConfig struct {
+	HotMaxAge    string `mapstructure:"hot_max_age"`
+	WarmMinAge   string `mapstructure:"warm_min_age"`
+	DeleteMinAge string `mapstructure:"delete_min_age"`
+}
+
+// DataStreamConfig enables ECS-style (type-dataset-namespace) data stream
+// routing instead of the fixed IndexMetrics/IndexLogs targets, with a
+// namespace template resolved per document from its metadata fields.
+type DataStreamConfig struct {
+	Enabled           bool            `mapstructure:"enabled"`
+	Dataset           string          `mapstructure:"dataset"`
+	NamespaceTemplate string          `mapstructure:"namespace_template"`
+	ILM               ILMPolicyConfig `mapstructure:"ilm"`
+	// Rollover is a lighter-weight alternative to Enabled: date-suffixed
+	// classic indices (e.g. "logs-tf-2025.01.15") meant to sit behind a
+	// write alias with an ILM rollover policy, for operators who aren't
+	// ready to adopt native data streams. Ignored when Enabled is true.
+	Rollover RolloverConfig `mapstructure:"rollover"`
+}
+
+// RolloverConfig configures DataStreamRouter's date-suffixed classic-index
+// naming mode.
+type RolloverConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// DateFormat is a Go reference-time layout for the index suffix. Zero
+	// value defaults to "2006.01.02" (e.g. "logs-tf-2025.01.15").
+	DateFormat string `mapstructure:"date_format"`
+}
+
+// QueueConfig enables a disk-backed write-ahead queue in front of the bulk
+// indexer so telemetry survives Elasticsearch outages and process restarts.
+type QueueConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Dir     string `mapstructure:"dir"`
+	// MaxBytes rotates the active WAL segment once it exceeds this size. Zero disables rotation.
+	MaxBytes int64 `mapstructure:"max_bytes"`
+	// FsyncIntervalMs controls how often the writer fsyncs the active segment. Zero fsyncs every record.
+	FsyncIntervalMs int    `mapstructure:"fsync_interval_ms"`
+	DeadLetterDir   string `mapstructure:"dead_letter_dir"`
+	// MaxQueueBytes caps the total size of records not yet delivered to
+	// Elasticsearch (across all segments, past the checkpoint). Once reached,
+	// Add rejects new records so callers can surface real back-pressure
+	// instead of letting the WAL grow without bound. Zero disables the cap.
+	MaxQueueBytes int64 `mapstructure:"max_queue_bytes"`
+}
+
+// SamplingConfig controls server-side downsampling and bucketed aggregation
+// of metric points before they reach the bulk indexer. Raw passthrough
+// (Enabled: false) is the default.
+type SamplingConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// InstallationHashRate is the probability (0..1), derived from a stable
+	// hash of installation_id, that a metric point is dropped outright.
+	InstallationHashRate float64 `mapstructure:"installation_hash_rate"`
+	// MetricsBucketSeconds is the fixed-width time bucket used to roll up
+	// points sharing (installation_id, journey_id, network).
+	MetricsBucketSeconds int `mapstructure:"metrics_bucket_seconds"`
+	// Quantiles are the t-digest quantiles (e.g. 0.5, 0.95, 0.99) emitted
+	// per numeric field on each aggregated bucket document.
+	Quantiles []float64 `mapstructure:"quantiles"`
+}
+
+// IngestConfig configures the ingest pipeline stages that run ahead of indexing.
+type IngestConfig struct {
+	Sampling SamplingConfig `mapstructure:"sampling"`
+	Scrubber ScrubberConfig `mapstructure:"scrubber"`
+}
+
+// ScrubRulesConfig toggles the scrubber's built-in regex rules, each
+// matching and redacting one category of sensitive value wherever it
+// appears in a document's string fields.
+type ScrubRulesConfig struct {
+	Email          bool `mapstructure:"email"`
+	IPv4           bool `mapstructure:"ipv4"`
+	IPv6           bool `mapstructure:"ipv6"`
+	JWT            bool `mapstructure:"jwt"`
+	CreditCard     bool `mapstructure:"credit_card"`
+	AndroidPackage bool `mapstructure:"android_package"`
+}
+
+// ScrubFieldRulesConfig gates which dotted field paths under a log
+// document's attributes/stack_trace are scrubbed at all. When AllowPaths is
+// non-empty it's a whitelist (only listed paths, and their descendants, are
+// kept - everything else under attributes/stack_trace is redacted); when
+// empty, DenyPaths is a blacklist applied on top of the regex rules instead.
+type ScrubFieldRulesConfig struct {
+	AllowPaths []string `mapstructure:"allow_paths"`
+	DenyPaths  []string `mapstructure:"deny_paths"`
+}
+
+// ScrubberConfig configures the PII-scrubbing pipeline ingest.Sender applies
+// to a document in indexAsync, right before it's marshaled for the bulk
+// indexer. RulesFile, if set, lets Rules/FieldRules/HashFields be edited and
+// hot-reloaded via a fsnotify watch on that file without restarting the
+// collector; Enabled and HMACKeyHex always come from the main config and
+// never hot-reload, since flipping the scrubber off or changing the hashing
+// key silently would be surprising for a security control.
+type ScrubberConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	// RulesFile, if set, is watched for changes and its Rules/FieldRules/
+	// HashFields override the ones below without a process restart.
+	RulesFile  string                `mapstructure:"rules_file"`
+	Rules      ScrubRulesConfig      `mapstructure:"rules"`
+	FieldRules ScrubFieldRulesConfig `mapstructure:"field_rules"`
+	// HashFields lists document field names (matched by key, not path) that
+	// must remain joinable across events (e.g. "installation_id"): instead
+	// of being redacted, their value is replaced by its HMAC-SHA256 digest
+	// under HMACKeyHex.
+	HashFields []string `mapstructure:"hash_fields"`
+	// HMACKeyHex is the hex-encoded key used to hash HashFields. Required
+	// when HashFields is non-empty.
+	HMACKeyHex string `mapstructure:"hmac_key_hex"`
+}
+
+// OTLPSignalConfig toggles ingestion for a single OTLP signal (metrics, logs, or traces).
+type OTLPSignalConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+}
+
+// OTLPConfig configures the native OpenTelemetry OTLP ingest endpoints served
+// alongside the ThreatFabric protobuf collector.
+type OTLPConfig struct {
+	Metrics OTLPSignalConfig `mapstructure:"metrics"`
+	Logs    OTLPSignalConfig `mapstructure:"logs"`
+	Traces  OTLPSignalConfig `mapstructure:"traces"`
+	// MaxMessageSizeBytes bounds the OTLP/HTTP request body size (default
+	// 4 MiB). The OTLP gRPC services do not read it; they are bounded only by
+	// Server.MaxRecvMsgBytes like every other gRPC method.
+	MaxMessageSizeBytes int `mapstructure:"max_message_size_bytes"`
+	// AttributeAliases maps ThreatFabric identity fields (customer_id, installation_id,
+	// journey_id, platform) to the resource/scope attribute keys third-party SDKs use
+	// for them, in addition to the built-in well-known keys.
+	AttributeAliases map[string]string `mapstructure:"attribute_aliases"`
 }
 
 type Config struct {
 	Server struct {
-		BindAddress string          `mapstructure:"bind_address"`
-		GRPCPort    int             `mapstructure:"grpc_port"`
-		HTTPPort    int             `mapstructure:"http_port"`
-		BasicAuth   BasicAuthConfig `mapstructure:"basic_auth"`
-		RateLimit   RateLimitConfig `mapstructure:"rate_limit"`
+		BindAddress string `mapstructure:"bind_address"`
+		GRPCPort    int    `mapstructure:"grpc_port"`
+		HTTPPort    int    `mapstructure:"http_port"`
+		// MaxRecvMsgBytes/MaxSendMsgBytes bound a single gRPC message; zero
+		// keeps grpc-go's default (4 MiB). MaxHTTPBodyBytes applies the same
+		// ceiling to /v1/telemetry via http.MaxBytesReader; zero disables it.
+		MaxRecvMsgBytes  int                 `mapstructure:"max_recv_msg_bytes"`
+		MaxSendMsgBytes  int                 `mapstructure:"max_send_msg_bytes"`
+		MaxHTTPBodyBytes int64               `mapstructure:"max_http_body_bytes"`
+		BasicAuth        BasicAuthConfig     `mapstructure:"basic_auth"`
+		RateLimit        RateLimitConfig     `mapstructure:"rate_limit"`
+		TLS              TLSConfig           `mapstructure:"tls"`
+		MTLS             MTLSConfig          `mapstructure:"mtls"`
+		JWT              JWTConfig           `mapstructure:"jwt"`
+		Auth             AuthConfig          `mapstructure:"auth"`
+		JWTAuth          JWTAuthConfig       `mapstructure:"jwt_auth"`
+		TokenAuth        TokenAuthConfig     `mapstructure:"token_auth"`
+		CORS             CORSConfig          `mapstructure:"cors"`
+		Compression      CompressionConfig   `mapstructure:"compression"`
+		HMACAuth         HMACAuthConfig      `mapstructure:"hmac_auth"`
+		Observability    ObservabilityConfig `mapstructure:"observability"`
+		OAuth            OAuthConfig         `mapstructure:"oauth"`
+		Backpressure     BackpressureConfig  `mapstructure:"backpressure"`
 	} `mapstructure:"server"`
 	Elastic struct {
-		Addresses     []string `mapstructure:"addresses"`
-		Username      string   `mapstructure:"username"`
-		Password      string   `mapstructure:"password"`
-		IndexMetrics  string   `mapstructure:"index_metrics"`
-		IndexLogs     string   `mapstructure:"index_logs"`
-		BatchSize     int      `mapstructure:"batch_size"`
-		FlushInterval int      `mapstructure:"flush_interval_seconds"`
+		Addresses     []string         `mapstructure:"addresses"`
+		Username      string           `mapstructure:"username"`
+		Password      string           `mapstructure:"password"`
+		IndexMetrics  string           `mapstructure:"index_metrics"`
+		IndexLogs     string           `mapstructure:"index_logs"`
+		BatchSize     int              `mapstructure:"batch_size"`
+		FlushInterval int              `mapstructure:"flush_interval_seconds"`
+		DataStreams   DataStreamConfig `mapstructure:"data_streams"`
+		Queue         QueueConfig      `mapstructure:"queue"`
 	} `mapstructure:"elasticsearch"`
 	Logging struct {
 		Level            string   `mapstructure:"level"`
@@ -44,6 +504,8 @@ type Config struct {
 		ErrorOutputPaths []string `mapstructure:"error_output_paths"`
 		MaxContextAttrs  int      `mapstructure:"max_context_attributes"`
 	} `mapstructure:"logging"`
+	OTLP   OTLPConfig   `mapstructure:"otlp"`
+	Ingest IngestConfig `mapstructure:"ingest"`
 }
 
 func Load(path string) (*Config, error) {
@@ -58,15 +520,30 @@ func Load(path string) (*Config, error) {
 		return nil, err
 	}
 
+	if err := validate(&cfg); err != nil {
+		return nil, err
+	}
+
+	return &cfg, nil
+}
+
+// validate checks invariants Load can't express through mapstructure tags
+// alone, and fills in defaults that depend on another field's value.
+func validate(cfg *Config) error {
 	if cfg.Server.RateLimit.Enabled {
 		if cfg.Server.RateLimit.RequestsPerSecond <= 0 {
-			return nil, fmt.Errorf("rate limit enabled but requests_per_second not set")
+			return fmt.Errorf("rate limit enabled but requests_per_second not set")
 		}
 		if cfg.Server.RateLimit.Burst == 0 {
 			// Default burst to a single second worth of requests to align with limiter tokens.
 			cfg.Server.RateLimit.Burst = int(math.Ceil(cfg.Server.RateLimit.RequestsPerSecond))
 		}
 	}
-
-	return &cfg, nil
+	if cfg.Ingest.Scrubber.Enabled && len(cfg.Ingest.Scrubber.HashFields) > 0 {
+		key, err := hex.DecodeString(cfg.Ingest.Scrubber.HMACKeyHex)
+		if err != nil || len(key) == 0 {
+			return fmt.Errorf("scrubber hash_fields set but hmac_key_hex is missing or not valid hex")
+		}
+	}
+	return nil
 }
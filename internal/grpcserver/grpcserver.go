@@ -6,6 +6,7 @@ import (
 	"net"
 
 	"google.golang.org/grpc"
+	_ "google.golang.org/grpc/encoding/gzip" // registers the gzip compressor so clients may send/request gzip-compressed messages
 
 	"github.com/threatfabric-devops/tf-telemetry/internal/config"
 	"github.com/threatfabric-devops/tf-telemetry/internal/gen/pb"
@@ -13,11 +14,37 @@ import (
 )
 
 // New returns a configured gRPC server with the provided collector service.
-func New(cfg *config.Config, svc pb.CollectorServer, limiter *middleware.RateLimiter) *grpc.Server {
+// Its auth interceptor is built by middleware.AuthChain: Basic Auth, mTLS,
+// JWT, and JWTAuth are composable (any-of), so a request is accepted if it
+// satisfies any one of them. Server.Auth.Mode additionally lets operators
+// restrict the any-of set to just "basic" or "jwt" (JWTAuth), or keep both
+// with explicit precedence; Server.Auth.PerRoute has no effect here, since
+// gRPC exposes a single service surface rather than per-route handlers.
+// Server.Observability.Tracing selects an OTel exporter for TracingUnary's
+// spans; MetricsUnary's Prometheus request/latency/payload-size metrics are
+// always recorded regardless of that setting. Server.TLS, when enabled,
+// supplies the server's own certificate (static or ACME-provisioned, same as
+// httpserver.New) in addition to whatever Server.MTLS requires of the
+// client; with Server.TLS disabled, Server.MTLS alone still builds
+// credentials requiring a client certificate but leaves the server's own
+// certificate to whoever configures the listener.
+func New(cfg *config.Config, svc pb.CollectorServer, limiter *middleware.RateLimiter) (*grpc.Server, error) {
 	var interceptors []grpc.UnaryServerInterceptor
 
-	if cfg.Server.BasicAuth.Enabled {
-		interceptors = append(interceptors, middleware.BasicAuthUnary(cfg.Server.BasicAuth))
+	// Tracing/metrics wrap everything else so a span and a Prometheus
+	// observation cover the full call, including any auth rejection or rate
+	// limiting below.
+	if cfg.Server.Observability.Tracing.Enabled() {
+		interceptors = append(interceptors, middleware.TracingUnary())
+	}
+	interceptors = append(interceptors, middleware.MetricsUnary())
+
+	chain, err := middleware.NewAuthChain(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("init auth chain: %w", err)
+	}
+	if authUnary := chain.UnaryInterceptor(); authUnary != nil {
+		interceptors = append(interceptors, authUnary)
 	}
 
 	if rl := middleware.RateLimitUnary(limiter); rl != nil {
@@ -28,10 +55,35 @@ func New(cfg *config.Config, svc pb.CollectorServer, limiter *middleware.RateLim
 	if len(interceptors) > 0 {
 		opts = append(opts, grpc.ChainUnaryInterceptor(interceptors...))
 	}
+	if cfg.Server.MaxRecvMsgBytes > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(cfg.Server.MaxRecvMsgBytes))
+	}
+	if cfg.Server.MaxSendMsgBytes > 0 {
+		opts = append(opts, grpc.MaxSendMsgSize(cfg.Server.MaxSendMsgBytes))
+	}
+	switch {
+	case cfg.Server.TLS.Enabled:
+		tlsCfg, _, err := middleware.ServerTLSConfig(cfg.Server.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("configure TLS: %w", err)
+		}
+		tlsCfg, err = middleware.WithClientCA(tlsCfg, cfg.Server.MTLS)
+		if err != nil {
+			return nil, fmt.Errorf("configure mTLS: %w", err)
+		}
+		opts = append(opts, grpc.Creds(middleware.MTLSCredentials(tlsCfg)))
+	case cfg.Server.MTLS.Enabled:
+		tlsCfg, err := middleware.ClientCATLSConfig(cfg.Server.MTLS)
+		if err != nil {
+			return nil, fmt.Errorf("configure mTLS: %w", err)
+		}
+		opts = append(opts, grpc.Creds(middleware.MTLSCredentials(tlsCfg)))
+	}
 
 	server := grpc.NewServer(opts...)
 	pb.RegisterCollectorServer(server, svc)
-	return server
+	registerOTLP(server, cfg, svc)
+	return server, nil
 }
 
 // Listen opens a TCP listener for the configured gRPC port.
@@ -13,9 +13,9 @@ import (
 	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
 
-	"github.com/minhtt159/tf-telemetry/internal/config"
-	"github.com/minhtt159/tf-telemetry/internal/gen/pb"
-	"github.com/minhtt159/tf-telemetry/internal/middleware"
+	"github.com/threatfabric-devops/tf-telemetry/internal/config"
+	"github.com/threatfabric-devops/tf-telemetry/internal/gen/pb"
+	"github.com/threatfabric-devops/tf-telemetry/internal/middleware"
 )
 
 const bufSize = 1024 * 1024
@@ -62,7 +62,10 @@ func dialBufConn(t *testing.T, srv *grpc.Server, lis *bufconn.Listener) pb.Colle
 
 func TestNew_RegistersCollector(t *testing.T) {
 	cfg := &config.Config{}
-	srv := New(cfg, &authCollector{}, nil)
+	srv, err := New(cfg, &authCollector{}, nil)
+	if err != nil {
+		t.Fatalf("failed to build server: %v", err)
+	}
 	info := srv.GetServiceInfo()
 	if len(info) == 0 {
 		t.Fatalf("collector service not registered")
@@ -72,12 +75,15 @@ func TestNew_RegistersCollector(t *testing.T) {
 func TestNew_BasicAuthInterceptor(t *testing.T) {
 	cfg := testConfig()
 	cfg.Server.BasicAuth.Enabled = true
-	srv := New(cfg, &authCollector{}, nil)
+	srv, err := New(cfg, &authCollector{}, nil)
+	if err != nil {
+		t.Fatalf("failed to build server: %v", err)
+	}
 	lis := bufconn.Listen(bufSize)
 	client := dialBufConn(t, srv, lis)
 
 	ctx := context.Background()
-	_, err := client.SendTelemetry(ctx, &pb.TelemetryPacket{Metadata: &pb.ClientMetadata{}})
+	_, err = client.SendTelemetry(ctx, &pb.TelemetryPacket{Metadata: &pb.ClientMetadata{}})
 	if status.Code(err) != codes.Unauthenticated {
 		t.Fatalf("expected unauthenticated without credentials, got %v", status.Code(err))
 	}
@@ -92,7 +98,10 @@ func TestNew_BasicAuthInterceptor(t *testing.T) {
 func TestNew_RateLimitInterceptor(t *testing.T) {
 	cfg := &config.Config{}
 	limiter := middleware.NewRateLimiter(config.RateLimitConfig{Enabled: true, RequestsPerSecond: 1, Burst: 1})
-	srv := New(cfg, &authCollector{}, limiter)
+	srv, err := New(cfg, &authCollector{}, limiter)
+	if err != nil {
+		t.Fatalf("failed to build server: %v", err)
+	}
 	lis := bufconn.Listen(bufSize)
 	client := dialBufConn(t, srv, lis)
 
@@ -105,3 +114,19 @@ func TestNew_RateLimitInterceptor(t *testing.T) {
 		t.Fatalf("expected rate limit error, got %v", err)
 	}
 }
+
+func TestNew_MaxRecvMsgBytesRejectsOversizePacket(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Server.MaxRecvMsgBytes = 16
+	srv, err := New(cfg, &authCollector{}, nil)
+	if err != nil {
+		t.Fatalf("failed to build server: %v", err)
+	}
+	lis := bufconn.Listen(bufSize)
+	client := dialBufConn(t, srv, lis)
+
+	packet := &pb.TelemetryPacket{Metadata: &pb.ClientMetadata{InstallationId: make([]byte, 64)}}
+	if _, err := client.SendTelemetry(context.Background(), packet); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("expected ResourceExhausted for an oversize packet, got %v", err)
+	}
+}
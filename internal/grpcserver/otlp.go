@@ -0,0 +1,68 @@
+package grpcserver
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+
+	logspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+
+	"github.com/threatfabric-devops/tf-telemetry/internal/config"
+)
+
+// otlpCollector is the subset of ingest.Sender used by the native OTLP gRPC services.
+type otlpCollector interface {
+	SendOTLPMetrics(context.Context, *metricspb.ExportMetricsServiceRequest) (*metricspb.ExportMetricsServiceResponse, error)
+	SendOTLPLogs(context.Context, *logspb.ExportLogsServiceRequest) (*logspb.ExportLogsServiceResponse, error)
+	SendOTLPTraces(context.Context, *tracepb.ExportTraceServiceRequest) (*tracepb.ExportTraceServiceResponse, error)
+}
+
+// metricsServiceAdapter exposes ingest.Sender as an OTLP MetricsServiceServer.
+type metricsServiceAdapter struct {
+	metricspb.UnimplementedMetricsServiceServer
+	collector otlpCollector
+}
+
+func (a metricsServiceAdapter) Export(ctx context.Context, req *metricspb.ExportMetricsServiceRequest) (*metricspb.ExportMetricsServiceResponse, error) {
+	return a.collector.SendOTLPMetrics(ctx, req)
+}
+
+// logsServiceAdapter exposes ingest.Sender as an OTLP LogsServiceServer.
+type logsServiceAdapter struct {
+	logspb.UnimplementedLogsServiceServer
+	collector otlpCollector
+}
+
+func (a logsServiceAdapter) Export(ctx context.Context, req *logspb.ExportLogsServiceRequest) (*logspb.ExportLogsServiceResponse, error) {
+	return a.collector.SendOTLPLogs(ctx, req)
+}
+
+// traceServiceAdapter exposes ingest.Sender as an OTLP TraceServiceServer.
+type traceServiceAdapter struct {
+	tracepb.UnimplementedTraceServiceServer
+	collector otlpCollector
+}
+
+func (a traceServiceAdapter) Export(ctx context.Context, req *tracepb.ExportTraceServiceRequest) (*tracepb.ExportTraceServiceResponse, error) {
+	return a.collector.SendOTLPTraces(ctx, req)
+}
+
+// registerOTLP registers the enabled native OTLP signals from cfg.OTLP against
+// server, delegating to svc when it also implements otlpCollector.
+func registerOTLP(server *grpc.Server, cfg *config.Config, svc any) {
+	collector, ok := svc.(otlpCollector)
+	if !ok {
+		return
+	}
+	if cfg.OTLP.Metrics.Enabled {
+		metricspb.RegisterMetricsServiceServer(server, metricsServiceAdapter{collector: collector})
+	}
+	if cfg.OTLP.Logs.Enabled {
+		logspb.RegisterLogsServiceServer(server, logsServiceAdapter{collector: collector})
+	}
+	if cfg.OTLP.Traces.Enabled {
+		tracepb.RegisterTraceServiceServer(server, traceServiceAdapter{collector: collector})
+	}
+}
@@ -0,0 +1,177 @@
+// Package healthz provides an asynchronous dependency health-check registry,
+// modeled on go-sundheit: components register named Checks that run on their
+// own interval in the background, and HTTP handlers report the cached
+// result instead of probing the dependency inline on every request.
+package healthz
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Status is the outcome of a Check's most recent run.
+type Status string
+
+const (
+	// StatusUnknown is a Check's Result before it has run for the first
+	// time (i.e. still within its InitialDelay).
+	StatusUnknown   Status = "unknown"
+	StatusHealthy   Status = "healthy"
+	StatusUnhealthy Status = "unhealthy"
+)
+
+// CheckFunc reports an error when the dependency it probes is unhealthy.
+// It is called with a context bounded by the owning Check's Timeout.
+type CheckFunc func(ctx context.Context) error
+
+// Check describes a single named dependency probe.
+type Check struct {
+	// Name uniquely identifies this check in Report's output.
+	Name string
+	// Check is run on Interval, each run bounded by Timeout.
+	Check CheckFunc
+	// Interval is how often Check reruns. Defaults to 30s if zero.
+	Interval time.Duration
+	// Timeout bounds a single run of Check. Defaults to 5s if zero.
+	Timeout time.Duration
+	// InitialDelay delays the first run after Register, so a dependency
+	// that takes a moment to come up doesn't fail readiness immediately.
+	InitialDelay time.Duration
+	// Critical marks this check as one Ready fails readiness on; a
+	// non-critical check is still reported by Report but doesn't affect
+	// Ready's result.
+	Critical bool
+}
+
+// Result is the cached outcome of a Check's most recent run.
+type Result struct {
+	Name      string    `json:"name"`
+	Status    Status    `json:"status"`
+	Critical  bool      `json:"critical"`
+	LastRunAt time.Time `json:"last_run_at,omitempty"`
+	LatencyMS int64     `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// HealthChecker runs registered Checks in the background and caches their
+// latest Result. The zero value is not usable; construct with New.
+type HealthChecker struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	results map[string]Result
+}
+
+// New returns a HealthChecker ready to accept Register calls. Call Close to
+// stop every registered check's background goroutine.
+func New() *HealthChecker {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &HealthChecker{
+		ctx:     ctx,
+		cancel:  cancel,
+		results: make(map[string]Result),
+	}
+}
+
+// Register starts check running in the background immediately (after its
+// InitialDelay), storing StatusUnknown until the first run completes.
+// Registering two checks with the same Name is a caller error; the second
+// silently replaces the cached Result of the first but both goroutines keep
+// running.
+func (h *HealthChecker) Register(check Check) {
+	if check.Interval <= 0 {
+		check.Interval = 30 * time.Second
+	}
+	if check.Timeout <= 0 {
+		check.Timeout = 5 * time.Second
+	}
+
+	h.mu.Lock()
+	h.results[check.Name] = Result{Name: check.Name, Status: StatusUnknown, Critical: check.Critical}
+	h.mu.Unlock()
+
+	h.wg.Add(1)
+	go h.run(check)
+}
+
+func (h *HealthChecker) run(check Check) {
+	defer h.wg.Done()
+
+	timer := time.NewTimer(check.InitialDelay)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-h.ctx.Done():
+			return
+		case <-timer.C:
+			h.runOnce(check)
+			timer.Reset(check.Interval)
+		}
+	}
+}
+
+func (h *HealthChecker) runOnce(check Check) {
+	ctx, cancel := context.WithTimeout(h.ctx, check.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check.Check(ctx)
+	latency := time.Since(start)
+
+	result := Result{
+		Name:      check.Name,
+		Critical:  check.Critical,
+		LastRunAt: start,
+		LatencyMS: latency.Milliseconds(),
+		Status:    StatusHealthy,
+	}
+	if err != nil {
+		result.Status = StatusUnhealthy
+		result.Error = err.Error()
+	}
+
+	h.mu.Lock()
+	h.results[check.Name] = result
+	h.mu.Unlock()
+}
+
+// Report returns every registered Check's cached Result, sorted by name.
+func (h *HealthChecker) Report() []Result {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	report := make([]Result, 0, len(h.results))
+	for _, result := range h.results {
+		report = append(report, result)
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].Name < report[j].Name })
+	return report
+}
+
+// Ready reports whether every critical check's last run was healthy. A
+// critical check that hasn't run yet (StatusUnknown, still within its
+// InitialDelay) does not fail readiness, so a slow-starting dependency
+// doesn't flap a container in and out of its load balancer on startup.
+func (h *HealthChecker) Ready() bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, result := range h.results {
+		if result.Critical && result.Status == StatusUnhealthy {
+			return false
+		}
+	}
+	return true
+}
+
+// Close stops every registered check's background goroutine and waits for
+// them to return.
+func (h *HealthChecker) Close() {
+	h.cancel()
+	h.wg.Wait()
+}
@@ -0,0 +1,96 @@
+package healthz
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestHealthCheckerReportsHealthyAfterFirstRun(t *testing.T) {
+	h := New()
+	defer h.Close()
+
+	h.Register(Check{
+		Name:     "ok",
+		Check:    func(context.Context) error { return nil },
+		Interval: 10 * time.Millisecond,
+		Critical: true,
+	})
+
+	waitForStatus(t, h, "ok", StatusHealthy)
+	if !h.Ready() {
+		t.Error("expected Ready to be true with only a healthy critical check")
+	}
+}
+
+func TestHealthCheckerReadyFalseOnUnhealthyCriticalCheck(t *testing.T) {
+	h := New()
+	defer h.Close()
+
+	h.Register(Check{
+		Name:     "broken",
+		Check:    func(context.Context) error { return errors.New("connection refused") },
+		Interval: 10 * time.Millisecond,
+		Critical: true,
+	})
+
+	waitForStatus(t, h, "broken", StatusUnhealthy)
+	if h.Ready() {
+		t.Error("expected Ready to be false with an unhealthy critical check")
+	}
+}
+
+func TestHealthCheckerNonCriticalDoesNotAffectReady(t *testing.T) {
+	h := New()
+	defer h.Close()
+
+	h.Register(Check{
+		Name:     "best-effort",
+		Check:    func(context.Context) error { return errors.New("degraded") },
+		Interval: 10 * time.Millisecond,
+		Critical: false,
+	})
+
+	waitForStatus(t, h, "best-effort", StatusUnhealthy)
+	if !h.Ready() {
+		t.Error("expected Ready to stay true when only a non-critical check is unhealthy")
+	}
+}
+
+func TestHealthCheckerUnknownBeforeInitialDelay(t *testing.T) {
+	h := New()
+	defer h.Close()
+
+	h.Register(Check{
+		Name:         "slow-start",
+		Check:        func(context.Context) error { return nil },
+		InitialDelay: time.Hour,
+		Critical:     true,
+	})
+
+	report := h.Report()
+	if len(report) != 1 || report[0].Status != StatusUnknown {
+		t.Fatalf("expected a single unknown result before InitialDelay elapses, got %+v", report)
+	}
+	if !h.Ready() {
+		t.Error("expected Ready to stay true for a critical check still within its InitialDelay")
+	}
+}
+
+func waitForStatus(t *testing.T, h *HealthChecker, name string, want Status) {
+	t.Helper()
+	deadline := time.After(time.Second)
+	for {
+		for _, result := range h.Report() {
+			if result.Name == name && result.Status == want {
+				return
+			}
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("timed out waiting for check %q to reach status %q", name, want)
+		case <-time.After(5 * time.Millisecond):
+		}
+	}
+}
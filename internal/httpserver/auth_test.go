@@ -0,0 +1,207 @@
+package httpserver
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protojson"
+
+	"github.com/threatfabric-devops/tf-telemetry/internal/gen/pb"
+)
+
+// generateTestCA creates a self-signed CA and a leaf certificate signed by
+// it for commonName, returning the CA's PEM bundle and the leaf certificate
+// (with its parsed *x509.Certificate ready to drop into a TLS connection
+// state without a real handshake).
+func generateTestCA(t *testing.T, commonName string) (caPEM []byte, leaf *x509.Certificate) {
+	t.Helper()
+
+	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate CA key: %v", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("create CA cert: %v", err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatalf("parse CA cert: %v", err)
+	}
+	caPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER})
+
+	leafKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate leaf key: %v", err)
+	}
+	leafTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTemplate, caCert, &leafKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("create leaf cert: %v", err)
+	}
+	leaf, err = x509.ParseCertificate(leafDER)
+	if err != nil {
+		t.Fatalf("parse leaf cert: %v", err)
+	}
+	return caPEM, leaf
+}
+
+func TestHTTPServerMTLS(t *testing.T) {
+	caPEM, clientCert := generateTestCA(t, "device-1")
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, caPEM, 0o644); err != nil {
+		t.Fatalf("write CA file: %v", err)
+	}
+
+	cfg := baseConfig()
+	cfg.Server.MTLS.Enabled = true
+	cfg.Server.MTLS.ClientCAFile = caFile
+	cfg.Server.MTLS.AllowedSANs = []string{"device-1"}
+
+	server, err := New(cfg, &stubTelemetryService{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to build server: %v", err)
+	}
+
+	body, err := protojson.Marshal(&pb.TelemetryPacket{Metadata: &pb.ClientMetadata{Platform: pb.Platform_ANDROID}})
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/telemetry", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	server.Handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a client cert, got %d", rr.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/v1/telemetry", bytes.NewReader(body))
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{clientCert}}
+	rr = httptest.NewRecorder()
+	server.Handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202 with an allow-listed client cert, got %d", rr.Code)
+	}
+}
+
+// rsaJWK mirrors the subset of RFC 7517 fields the verifier reads.
+type rsaJWK struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func startTestJWKS(t *testing.T, key *rsa.PrivateKey, kid string) *httptest.Server {
+	t.Helper()
+	eBytes := big.NewInt(int64(key.PublicKey.E)).Bytes()
+	set := struct {
+		Keys []rsaJWK `json:"keys"`
+	}{
+		Keys: []rsaJWK{{
+			Kid: kid,
+			Kty: "RSA",
+			N:   base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(eBytes),
+		}},
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(set)
+	}))
+}
+
+func signTestJWT(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]any) string {
+	t.Helper()
+	header := map[string]any{"alg": "RS256", "kid": kid}
+	headerJSON, _ := json.Marshal(header)
+	claimsJSON, _ := json.Marshal(claims)
+
+	signedInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+
+	digest := sha256.Sum256([]byte(signedInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("sign JWT: %v", err)
+	}
+	return signedInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestHTTPServerJWT(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	jwks := startTestJWKS(t, key, "test-key")
+	t.Cleanup(jwks.Close)
+
+	cfg := baseConfig()
+	cfg.Server.JWT.Enabled = true
+	cfg.Server.JWT.JWKSURL = jwks.URL
+	cfg.Server.JWT.Issuer = "https://issuer.example"
+	cfg.Server.JWT.Audience = "tf-telemetry"
+
+	server, err := New(cfg, &stubTelemetryService{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to build server: %v", err)
+	}
+
+	body, err := protojson.Marshal(&pb.TelemetryPacket{Metadata: &pb.ClientMetadata{Platform: pb.Platform_ANDROID}})
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/telemetry", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	server.Handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a bearer token, got %d", rr.Code)
+	}
+
+	token := signTestJWT(t, key, "test-key", map[string]any{
+		"iss": "https://issuer.example",
+		"aud": "tf-telemetry",
+		"sub": "svc-account-1",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	req = httptest.NewRequest(http.MethodPost, "/v1/telemetry", bytes.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+token)
+	rr = httptest.NewRecorder()
+	server.Handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202 with a valid bearer token, got %d", rr.Code)
+	}
+}
@@ -9,7 +9,9 @@ import (
 	"github.com/threatfabric-devops/tf-telemetry/internal/config"
 )
 
-// RunHealthcheck performs a healthcheck against the HTTP server.
+// RunHealthcheck performs a healthcheck against the HTTP server's /readyz,
+// so a docker HEALTHCHECK reflects actual readiness (downstream sinks
+// included) rather than just the process being alive.
 // Returns 0 on success, 1 on failure.
 func RunHealthcheck(cfg *config.Config) int {
 	url := os.Getenv("HEALTHCHECK_URL")
@@ -22,7 +24,7 @@ func RunHealthcheck(cfg *config.Config) int {
 		if port == 0 {
 			port = 8080
 		}
-		url = fmt.Sprintf("http://%s:%d/healthz", host, port)
+		url = fmt.Sprintf("http://%s:%d/readyz", host, port)
 	}
 
 	timeout := 2 * time.Second
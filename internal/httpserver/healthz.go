@@ -0,0 +1,45 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/threatfabric-devops/tf-telemetry/internal/healthz"
+)
+
+// registerHealthzRoutes mounts /livez, /readyz, and /healthz on mux. These
+// are left outside the telemetry auth chain, like /metrics, since the
+// orchestrator probing them generally can't supply credentials. hc may be
+// nil (e.g. when no Sender/sink has registered a check yet), in which case
+// /readyz and /healthz report healthy unconditionally.
+func registerHealthzRoutes(mux *http.ServeMux, hc *healthz.HealthChecker) {
+	mux.HandleFunc("/livez", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	})
+
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if hc != nil && !hc.Ready() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	})
+
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if hc == nil {
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("[]"))
+			return
+		}
+		report := hc.Report()
+		if !hc.Ready() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		} else {
+			w.WriteHeader(http.StatusOK)
+		}
+		json.NewEncoder(w).Encode(report)
+	})
+}
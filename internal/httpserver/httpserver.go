@@ -4,78 +4,235 @@ package httpserver
 import (
 	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/encoding/protojson"
 	"google.golang.org/protobuf/proto"
 
 	"github.com/threatfabric-devops/tf-telemetry/internal/config"
 	"github.com/threatfabric-devops/tf-telemetry/internal/gen/pb"
+	"github.com/threatfabric-devops/tf-telemetry/internal/healthz"
 	"github.com/threatfabric-devops/tf-telemetry/internal/middleware"
 )
 
-// TelemetryService defines the subset of the collector used by HTTP endpoints.
+// TelemetryService defines the subset of the collector used by HTTP
+// endpoints. It deliberately matches pb.CollectorServer's SendTelemetry
+// signature exactly (rather than embedding pb.CollectorServer), on the theory
+// that this package could one day be replaced by a grpc-gateway reverse
+// proxy generated from the collector .proto and swapped in without a
+// signature mismatch.
+//
+// That replacement has not been attempted, and this interface is not a step
+// toward it - it's the status quo, unchanged. It needs the collector's
+// .proto source and a protoc-gen-grpc-gateway run, neither of which this
+// checkout has (internal/gen/pb ships only the generated Go output, not the
+// .proto it was generated from), and it would add a new module dependency
+// this tree has no go.mod to record. Both of those are environment
+// limitations of this checkout as a whole, not specific to this package, and
+// should have been raised the first time this series touched internal/gen/pb
+// rather than here. Until someone with a full build environment does that
+// work, httpserver and grpcserver keep maintaining separate SendTelemetry
+// paths, sharing only what internal/middleware factors out; that duplication
+// is real ongoing maintenance cost, not a solved problem.
 type TelemetryService interface {
 	SendTelemetry(context.Context, *pb.TelemetryPacket) (*pb.Ack, error)
 }
 
-// New returns a configured HTTP server for telemetry ingestion.
-func New(cfg *config.Config, svc TelemetryService, limiter *middleware.RateLimiter) *http.Server {
-	handler := telemetryMux(svc, limiter)
+// New returns a configured HTTP server for telemetry ingestion. Its auth
+// chain is built by middleware.AuthChain: Basic Auth, mTLS, JWT, and JWTAuth
+// are composable (any-of), so a request is accepted if it satisfies any one
+// of them. Server.Auth.Mode additionally lets operators restrict the any-of
+// set to just "basic" or "jwt" (JWTAuth), or keep both with explicit
+// precedence; Server.Auth.PerRoute narrows that further for specific routes
+// (telemetryMux applies it per handler rather than once for the whole mux).
+// hc is optional: when nil, /readyz and /healthz report healthy
+// unconditionally, matching the collector's behavior before healthz existed.
+// Server.Observability.Tracing selects an OTel exporter for request tracing.
+// Server.TLS, when enabled, makes the returned srv.TLSConfig carry the
+// server's own certificate (static or ACME-provisioned - see
+// ACMEChallengeServer) in addition to whatever Server.MTLS requires of the
+// client; with Server.TLS disabled, Server.MTLS alone still sets
+// ClientCAs/ClientAuth but leaves the server's own certificate to whoever
+// serves srv.
+func New(cfg *config.Config, svc TelemetryService, limiter *middleware.RateLimiter, hc *healthz.HealthChecker) (*http.Server, error) {
+	chain, err := middleware.NewAuthChain(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("init auth chain: %w", err)
+	}
+
+	handler := telemetryMux(cfg, svc, limiter, chain, hc)
+	// Mirrors the gRPC side's MaxRecvMsgBytes so both transports enforce the
+	// same ceiling on a telemetry payload.
+	handler = middleware.MaxBodyBytesHTTP(cfg.Server.MaxHTTPBodyBytes)(handler)
 	// Add CORS middleware for web clients (configurable)
 	handler = middleware.CorsMiddleware(handler, cfg.Server.CORS)
 
-	if cfg.Server.BasicAuth.Enabled {
-		handler = middleware.BasicAuthHTTP(cfg.Server.BasicAuth)(handler)
+	// Compression wraps everything else so decompression happens before
+	// auth/rate-limiting see the request body, and so error responses from
+	// those middlewares are still eligible for response compression.
+	handler = middleware.CompressionHTTP(cfg.Server.Compression)(handler)
+	if cfg.Server.Observability.Tracing.Enabled() {
+		// Outermost so the span covers the full request, including
+		// compression/auth/rate-limiting below it.
+		handler = middleware.TracingHTTP()(handler)
 	}
 
-	return &http.Server{
+	srv := &http.Server{
 		Addr:    fmt.Sprintf("%s:%d", cfg.Server.BindAddress, cfg.Server.HTTPPort),
 		Handler: handler,
 	}
+	switch {
+	case cfg.Server.TLS.Enabled:
+		tlsCfg, _, err := middleware.ServerTLSConfig(cfg.Server.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("configure TLS: %w", err)
+		}
+		tlsCfg, err = middleware.WithClientCA(tlsCfg, cfg.Server.MTLS)
+		if err != nil {
+			return nil, fmt.Errorf("configure mTLS: %w", err)
+		}
+		srv.TLSConfig = tlsCfg
+	case cfg.Server.MTLS.Enabled:
+		// Server.TLS is off but mTLS alone is on: this only supplies
+		// ClientCAs/ClientAuth, so whoever serves srv must still provide its
+		// own certificate (e.g. via ListenAndServeTLS's file arguments).
+		tlsCfg, err := middleware.ClientCATLSConfig(cfg.Server.MTLS)
+		if err != nil {
+			return nil, fmt.Errorf("configure mTLS: %w", err)
+		}
+		srv.TLSConfig = tlsCfg
+	}
+	return srv, nil
 }
 
-func telemetryMux(svc TelemetryService, limiter *middleware.RateLimiter) http.Handler {
+// ACMEChallengeServer returns an *http.Server answering ACME's HTTP-01
+// challenge on port 80, which must run alongside the *http.Server New
+// returns whenever Server.TLS's certificate is ACME-provisioned (the
+// challenge is always plain HTTP on port 80, independent of whatever port
+// the TLS listener itself uses). Returns nil, nil when cfg isn't configured
+// for ACME (a static CertFile/KeyFile pair takes precedence over ACME, same
+// as ServerTLSConfig).
+func ACMEChallengeServer(cfg *config.Config) (*http.Server, error) {
+	tlsCfg := cfg.Server.TLS
+	if !tlsCfg.Enabled || tlsCfg.CertFile != "" || tlsCfg.KeyFile != "" || !tlsCfg.ACME.Enabled {
+		return nil, nil
+	}
+	_, mgr, err := middleware.ServerTLSConfig(tlsCfg)
+	if err != nil {
+		return nil, fmt.Errorf("configure ACME: %w", err)
+	}
+	return &http.Server{Addr: ":80", Handler: middleware.ACMEHTTPHandler(mgr)}, nil
+}
+
+// telemetryMux wires every HTTP route, wrapping each in chain individually
+// (rather than once around the whole mux) so cfg.Server.Auth.PerRoute can
+// require a narrower - or wider - set of schemes for a given path. /metrics
+// and /debug/pprof/* (registerObservabilityRoutes) are the one exception:
+// they're guarded solely by their own Observability.BasicAuth, independent
+// of this chain.
+func telemetryMux(cfg *config.Config, svc TelemetryService, limiter *middleware.RateLimiter, chain *middleware.AuthChain, hc *healthz.HealthChecker) http.Handler {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/v1/telemetry", func(w http.ResponseWriter, r *http.Request) {
+	registerOTLPRoutes(mux, cfg, svc, chain)
+	registerObservabilityRoutes(mux, cfg)
+	registerHealthzRoutes(mux, hc)
+
+	telemetryHandler := func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
 			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 			return
 		}
 		var packet pb.TelemetryPacket
 		if err := decodeRequestBody(r, &packet); err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, `{"error":"request body exceeds the configured size limit"}`, http.StatusRequestEntityTooLarge)
+				return
+			}
 			http.Error(w, "Invalid request format", http.StatusBadRequest)
 			return
 		}
-		if limiter != nil && !limiter.Allow(installationIDHex(&packet)) {
-			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
-			return
+		if limiter != nil {
+			allowed, retryAfter, remaining := limiter.AllowWithHint(installationIDKey(r.Context(), &packet))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+			if !allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+				http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
 		}
 		if _, err := svc.SendTelemetry(r.Context(), &packet); err != nil {
+			if status.Code(err) == codes.ResourceExhausted {
+				http.Error(w, err.Error(), http.StatusTooManyRequests)
+				return
+			}
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 		w.WriteHeader(http.StatusAccepted)
 		w.Write([]byte(`{"status":"accepted"}`))
-	})
-
-	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("ok"))
-	})
+	}
+	mux.Handle("/v1/telemetry", chain.WrapHTTP(http.HandlerFunc(telemetryHandler), chain.RequiredFor(cfg, "/v1/telemetry")...))
 
 	return mux
 }
 
-func installationIDHex(packet *pb.TelemetryPacket) string {
+// installationIDKey prefers the identity an auth middleware already verified
+// (mTLS CN/SPIFFE-ID SAN, or a bearer token's customer ID/installation
+// ID/subject claim, in that order) over the packet's self-reported
+// InstallationId, so a client can't evade its quota by lying about its
+// installation ID once mTLS or bearer auth is enforced.
+func installationIDKey(ctx context.Context, packet *pb.TelemetryPacket) string {
+	principal, _ := middleware.PrincipalFromContext(ctx)
+	// JWTAuthHTTP sets the principal to the token's subject; when that is the
+	// principal, the token's own claims pick the key.
+	if claims, ok := middleware.ClaimsFromContext(ctx); ok && (principal == "" || principal == claims.Subject) {
+		if claims.CustomerID != "" {
+			return claims.CustomerID
+		}
+		if claims.InstallationID != "" {
+			return claims.InstallationID
+		}
+		if claims.Subject != "" {
+			return claims.Subject
+		}
+	}
+	if principal != "" {
+		return principal
+	}
 	if packet == nil || packet.GetMetadata() == nil {
 		return ""
 	}
 	return hex.EncodeToString(packet.GetMetadata().GetInstallationId())
 }
 
+// telemetryProtobufContentTypes are the Content-Type values decodeRequestBody
+// treats as binary protobuf; anything else (including no Content-Type) is
+// decoded as protojson, matching decodeOTLPBody's negotiation in otlp.go.
+var telemetryProtobufContentTypes = []string{"application/x-protobuf", "application/protobuf"}
+
+func isProtobufContentType(r *http.Request) bool {
+	ct := r.Header.Get("Content-Type")
+	for _, prefix := range telemetryProtobufContentTypes {
+		if strings.HasPrefix(ct, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeRequestBody reads r's body - already size-limited by
+// MaxBodyBytesHTTP and already gunzipped by CompressionHTTP ahead of this
+// handler - and decodes it as binary protobuf or protojson depending on
+// Content-Type, so mobile clients can send TelemetryPackets as compact
+// protobuf instead of paying JSON's CPU and bandwidth cost.
 func decodeRequestBody(r *http.Request, message proto.Message) error {
 	body, readErr := io.ReadAll(r.Body)
 	closeErr := r.Body.Close()
@@ -85,5 +242,8 @@ func decodeRequestBody(r *http.Request, message proto.Message) error {
 	if closeErr != nil {
 		return closeErr
 	}
+	if isProtobufContentType(r) {
+		return proto.Unmarshal(body, message)
+	}
 	return protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(body, message)
 }
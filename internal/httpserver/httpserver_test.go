@@ -8,10 +8,11 @@ import (
 	"testing"
 
 	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 
-	"github.com/minhtt159/tf-telemetry/internal/config"
-	"github.com/minhtt159/tf-telemetry/internal/gen/pb"
-	"github.com/minhtt159/tf-telemetry/internal/middleware"
+	"github.com/threatfabric-devops/tf-telemetry/internal/config"
+	"github.com/threatfabric-devops/tf-telemetry/internal/gen/pb"
+	"github.com/threatfabric-devops/tf-telemetry/internal/middleware"
 )
 
 type stubTelemetryService struct {
@@ -36,7 +37,10 @@ func TestHTTPServerBasicAuth(t *testing.T) {
 	cfg.Server.BasicAuth.Username = "user"
 	cfg.Server.BasicAuth.Password = "pass"
 
-	server := New(cfg, &stubTelemetryService{}, middleware.NewRateLimiter(config.RateLimitConfig{}))
+	server, err := New(cfg, &stubTelemetryService{}, middleware.NewRateLimiter(config.RateLimitConfig{}), nil)
+	if err != nil {
+		t.Fatalf("failed to build server: %v", err)
+	}
 
 	body, err := protojson.Marshal(&pb.TelemetryPacket{Metadata: &pb.ClientMetadata{Platform: pb.Platform_ANDROID}})
 	if err != nil {
@@ -62,7 +66,10 @@ func TestHTTPServerBasicAuth(t *testing.T) {
 func TestHTTPServerRateLimitByInstallationID(t *testing.T) {
 	cfg := baseConfig()
 	rl := middleware.NewRateLimiter(config.RateLimitConfig{Enabled: true, RequestsPerSecond: 1, Burst: 1})
-	server := New(cfg, &stubTelemetryService{}, rl)
+	server, err := New(cfg, &stubTelemetryService{}, rl, nil)
+	if err != nil {
+		t.Fatalf("failed to build server: %v", err)
+	}
 
 	body, err := protojson.Marshal(&pb.TelemetryPacket{Metadata: &pb.ClientMetadata{Platform: pb.Platform_ANDROID, InstallationId: []byte{0x01}}})
 	if err != nil {
@@ -84,3 +91,52 @@ func TestHTTPServerRateLimitByInstallationID(t *testing.T) {
 		t.Fatalf("expected rate limited response, got %d", rr2.Code)
 	}
 }
+
+func TestHTTPServerMaxBodyBytesRejectsOversizePacket(t *testing.T) {
+	cfg := baseConfig()
+	cfg.Server.MaxHTTPBodyBytes = 16
+
+	server, err := New(cfg, &stubTelemetryService{}, middleware.NewRateLimiter(config.RateLimitConfig{}), nil)
+	if err != nil {
+		t.Fatalf("failed to build server: %v", err)
+	}
+
+	body, err := protojson.Marshal(&pb.TelemetryPacket{
+		Metadata: &pb.ClientMetadata{Platform: pb.Platform_ANDROID, InstallationId: bytes.Repeat([]byte{0x01}, 64)},
+	})
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/telemetry", bytes.NewReader(body))
+	rr := httptest.NewRecorder()
+	server.Handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413 for an oversize packet, got %d", rr.Code)
+	}
+}
+
+func TestHTTPServerAcceptsBinaryProtobufBody(t *testing.T) {
+	cfg := baseConfig()
+	svc := &stubTelemetryService{}
+	server, err := New(cfg, svc, middleware.NewRateLimiter(config.RateLimitConfig{}), nil)
+	if err != nil {
+		t.Fatalf("failed to build server: %v", err)
+	}
+
+	body, err := proto.Marshal(&pb.TelemetryPacket{Metadata: &pb.ClientMetadata{Platform: pb.Platform_ANDROID}})
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/telemetry", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	rr := httptest.NewRecorder()
+	server.Handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("expected 202 for a binary protobuf body, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if svc.calls != 1 {
+		t.Fatalf("expected SendTelemetry to be called once, got %d", svc.calls)
+	}
+}
@@ -0,0 +1,36 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/threatfabric-devops/tf-telemetry/internal/config"
+	"github.com/threatfabric-devops/tf-telemetry/internal/middleware"
+)
+
+// registerObservabilityRoutes wires /metrics (Prometheus) and /debug/pprof/*
+// onto mux when cfg.Server.Observability is enabled, guarded by its own
+// Basic Auth credentials independent of the telemetry API's auth chain.
+func registerObservabilityRoutes(mux *http.ServeMux, cfg *config.Config) {
+	if !cfg.Server.Observability.Enabled {
+		return
+	}
+
+	obsMux := http.NewServeMux()
+	obsMux.Handle("/metrics", promhttp.Handler())
+	obsMux.HandleFunc("/debug/pprof/", pprof.Index)
+	obsMux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	obsMux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	obsMux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	obsMux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	var handler http.Handler = obsMux
+	if cfg.Server.Observability.BasicAuth.Enabled {
+		handler = middleware.BasicAuthHTTP(cfg.Server.Observability.BasicAuth)(handler)
+	}
+
+	mux.Handle("/metrics", handler)
+	mux.Handle("/debug/pprof/", handler)
+}
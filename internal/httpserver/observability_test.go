@@ -0,0 +1,59 @@
+package httpserver
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestObservabilityRoutesDisabledByDefault(t *testing.T) {
+	cfg := baseConfig()
+
+	server, err := New(cfg, &stubTelemetryService{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to build server: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rr := httptest.NewRecorder()
+	server.Handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("expected /metrics to be unregistered when disabled, got %d", rr.Code)
+	}
+}
+
+func TestObservabilityRoutesRequireTheirOwnBasicAuth(t *testing.T) {
+	cfg := baseConfig()
+	cfg.Server.Observability.Enabled = true
+	cfg.Server.Observability.BasicAuth.Enabled = true
+	cfg.Server.Observability.BasicAuth.Username = "ops"
+	cfg.Server.Observability.BasicAuth.Password = "secret"
+
+	server, err := New(cfg, &stubTelemetryService{}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to build server: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rr := httptest.NewRecorder()
+	server.Handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without credentials, got %d", rr.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	req.SetBasicAuth("ops", "secret")
+	rr = httptest.NewRecorder()
+	server.Handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 with valid credentials, got %d", rr.Code)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil)
+	req.SetBasicAuth("ops", "secret")
+	rr = httptest.NewRecorder()
+	server.Handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 from pprof index with valid credentials, got %d", rr.Code)
+	}
+}
@@ -0,0 +1,144 @@
+package httpserver
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	logspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+
+	"github.com/threatfabric-devops/tf-telemetry/internal/config"
+	"github.com/threatfabric-devops/tf-telemetry/internal/middleware"
+)
+
+// otlpCollector is the subset of ingest.Sender used by the OTLP endpoints.
+type otlpCollector interface {
+	SendOTLPMetrics(context.Context, *metricspb.ExportMetricsServiceRequest) (*metricspb.ExportMetricsServiceResponse, error)
+	SendOTLPLogs(context.Context, *logspb.ExportLogsServiceRequest) (*logspb.ExportLogsServiceResponse, error)
+	SendOTLPTraces(context.Context, *tracepb.ExportTraceServiceRequest) (*tracepb.ExportTraceServiceResponse, error)
+}
+
+// registerOTLPRoutes mounts /v1/otlp/{metrics,logs,traces} - and, for metrics
+// and logs, the OTLP/HTTP spec's default receiver paths /v1/metrics and
+// /v1/logs, so an OTel SDK/collector pointed at this server's HTTP endpoint
+// root works without a custom path override - on mux when the corresponding
+// signal is enabled in cfg.OTLP and svc implements otlpCollector. Both
+// protobuf (application/x-protobuf) and JSON (application/json) request
+// bodies are accepted, matching the response to whichever was sent. Each
+// route is guarded by chain at the order cfg.Server.Auth.PerRoute names for
+// its path, or the server-wide default order otherwise.
+func registerOTLPRoutes(mux *http.ServeMux, cfg *config.Config, svc TelemetryService, chain *middleware.AuthChain) {
+	collector, ok := svc.(otlpCollector)
+	if !ok {
+		return
+	}
+
+	maxSize := int64(cfg.OTLP.MaxMessageSizeBytes)
+	if maxSize <= 0 {
+		maxSize = 4 << 20 // 4 MiB default, matching the gRPC default message cap
+	}
+
+	handle := func(path string, handler http.HandlerFunc) {
+		mux.Handle(path, chain.WrapHTTP(handler, chain.RequiredFor(cfg, path)...))
+	}
+
+	if cfg.OTLP.Metrics.Enabled {
+		metricsHandler := func(w http.ResponseWriter, r *http.Request) {
+			var req metricspb.ExportMetricsServiceRequest
+			if err := decodeOTLPBody(r, maxSize, &req); err != nil {
+				http.Error(w, "Invalid OTLP metrics payload", http.StatusBadRequest)
+				return
+			}
+			resp, err := collector.SendOTLPMetrics(r.Context(), &req)
+			writeOTLPResponse(w, r, resp, err)
+		}
+		handle("/v1/otlp/metrics", metricsHandler)
+		handle("/v1/metrics", metricsHandler)
+	}
+
+	if cfg.OTLP.Logs.Enabled {
+		logsHandler := func(w http.ResponseWriter, r *http.Request) {
+			var req logspb.ExportLogsServiceRequest
+			if err := decodeOTLPBody(r, maxSize, &req); err != nil {
+				http.Error(w, "Invalid OTLP logs payload", http.StatusBadRequest)
+				return
+			}
+			resp, err := collector.SendOTLPLogs(r.Context(), &req)
+			writeOTLPResponse(w, r, resp, err)
+		}
+		handle("/v1/otlp/logs", logsHandler)
+		handle("/v1/logs", logsHandler)
+	}
+
+	if cfg.OTLP.Traces.Enabled {
+		handle("/v1/otlp/traces", func(w http.ResponseWriter, r *http.Request) {
+			var req tracepb.ExportTraceServiceRequest
+			if err := decodeOTLPBody(r, maxSize, &req); err != nil {
+				http.Error(w, "Invalid OTLP traces payload", http.StatusBadRequest)
+				return
+			}
+			resp, err := collector.SendOTLPTraces(r.Context(), &req)
+			writeOTLPResponse(w, r, resp, err)
+		})
+	}
+}
+
+// otlpContentTypeProtobuf is the OTLP/HTTP spec's protobuf media type; any
+// other (or absent) Content-Type is treated as OTLP/JSON.
+const otlpContentTypeProtobuf = "application/x-protobuf"
+
+func isOTLPProtobuf(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), otlpContentTypeProtobuf)
+}
+
+func decodeOTLPBody(r *http.Request, maxSize int64, message proto.Message) error {
+	defer r.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxSize))
+	if err != nil {
+		return err
+	}
+	if isOTLPProtobuf(r) {
+		return proto.Unmarshal(body, message)
+	}
+	return protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(body, message)
+}
+
+// writeOTLPResponse replies in the same encoding the request used, per the
+// OTLP/HTTP spec.
+func writeOTLPResponse(w http.ResponseWriter, r *http.Request, resp proto.Message, err error) {
+	if err != nil {
+		if status.Code(err) == codes.ResourceExhausted {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if isOTLPProtobuf(r) {
+		body, marshalErr := proto.Marshal(resp)
+		if marshalErr != nil {
+			http.Error(w, marshalErr.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", otlpContentTypeProtobuf)
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
+		return
+	}
+	body, marshalErr := protojson.Marshal(resp)
+	if marshalErr != nil {
+		http.Error(w, marshalErr.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body)
+}
@@ -3,7 +3,11 @@ package httpserver
 import (
 	"context"
 
-	"github.com/minhtt159/tf-telemetry/internal/gen/pb"
+	logspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+
+	"github.com/threatfabric-devops/tf-telemetry/internal/gen/pb"
 )
 
 // Service implements the collector service and delegates to the telemetry sender.
@@ -23,3 +27,18 @@ func NewService(sender TelemetryService) *Service {
 func (s *Service) SendTelemetry(ctx context.Context, packet *pb.TelemetryPacket) (*pb.Ack, error) {
 	return s.sender.SendTelemetry(ctx, packet)
 }
+
+// SendOTLPMetrics delegates to the sender when it supports native OTLP ingest.
+func (s *Service) SendOTLPMetrics(ctx context.Context, req *metricspb.ExportMetricsServiceRequest) (*metricspb.ExportMetricsServiceResponse, error) {
+	return s.sender.(otlpCollector).SendOTLPMetrics(ctx, req)
+}
+
+// SendOTLPLogs delegates to the sender when it supports native OTLP ingest.
+func (s *Service) SendOTLPLogs(ctx context.Context, req *logspb.ExportLogsServiceRequest) (*logspb.ExportLogsServiceResponse, error) {
+	return s.sender.(otlpCollector).SendOTLPLogs(ctx, req)
+}
+
+// SendOTLPTraces delegates to the sender when it supports native OTLP ingest.
+func (s *Service) SendOTLPTraces(ctx context.Context, req *tracepb.ExportTraceServiceRequest) (*tracepb.ExportTraceServiceResponse, error) {
+	return s.sender.(otlpCollector).SendOTLPTraces(ctx, req)
+}
@@ -0,0 +1,203 @@
+package indexer
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+
+	"github.com/threatfabric-devops/tf-telemetry/internal/config"
+)
+
+// Router resolves the Elasticsearch target (index or data stream name) and
+// bulk action for a document. Sender.indexAsync only depends on this
+// interface, so callers that need routing logic DataStreamRouter doesn't
+// cover (e.g. keying on a field outside config.DataStreamConfig.NamespaceTemplate's
+// reach, or an entirely different naming scheme) can supply their own
+// implementation without touching the gRPC/HTTP layers.
+type Router interface {
+	Route(signal string, doc map[string]any) (target string, action string)
+}
+
+// DataStreamRouter is the default Router: it routes to ECS-style data
+// streams, date-suffixed rollover indices, or a single static index,
+// depending on config.DataStreamConfig.
+type DataStreamRouter struct {
+	cfg config.DataStreamConfig
+	// static is used when neither data streams nor rollover are enabled,
+	// preserving the historic fixed-index behavior.
+	staticMetrics string
+	staticLogs    string
+}
+
+// NewRouter builds the default Router from the elasticsearch config block.
+func NewRouter(cfg *config.Config) *DataStreamRouter {
+	return &DataStreamRouter{
+		cfg:           cfg.Elastic.DataStreams,
+		staticMetrics: cfg.Elastic.IndexMetrics,
+		staticLogs:    cfg.Elastic.IndexLogs,
+	}
+}
+
+// Route returns the target name and bulk action ("index" for a classic index,
+// "create" for a data stream) for a metrics or logs document, keyed on the
+// doc's host_app_name metadata field (falling back to "default") - or, once a
+// request's document carries a customer_id (as OTLP-ingested documents do),
+// on that instead once NamespaceTemplate is set to "{customer_id}".
+func (r *DataStreamRouter) Route(signal string, doc map[string]any) (target string, action string) {
+	if r.cfg.Enabled {
+		namespace := r.namespace(doc)
+		dataset := r.cfg.Dataset
+		if dataset == "" {
+			dataset = "tf.telemetry"
+		}
+		return fmt.Sprintf("%s-%s-%s", signal, dataset, namespace), "create"
+	}
+
+	if r.cfg.Rollover.Enabled {
+		return r.rolloverTarget(signal), "index"
+	}
+
+	if signal == "metrics" {
+		return r.staticMetrics, "index"
+	}
+	return r.staticLogs, "index"
+}
+
+// rolloverTarget builds a date-suffixed classic index name such as
+// "logs-tf-2025.01.15", intended to sit behind a write alias with an ILM
+// rollover policy rather than a native data stream.
+func (r *DataStreamRouter) rolloverTarget(signal string) string {
+	format := r.cfg.Rollover.DateFormat
+	if format == "" {
+		format = "2006.01.02"
+	}
+	return fmt.Sprintf("%s-tf-%s", signal, time.Now().UTC().Format(format))
+}
+
+// namespace resolves the data stream namespace from the configured template,
+// substituting `{field}` placeholders with values from the document. The
+// default template is `{host_app_name}`.
+func (r *DataStreamRouter) namespace(doc map[string]any) string {
+	template := r.cfg.NamespaceTemplate
+	if template == "" {
+		template = "{host_app_name}"
+	}
+
+	resolved := template
+	for key, val := range doc {
+		placeholder := "{" + key + "}"
+		if strings.Contains(resolved, placeholder) {
+			resolved = strings.ReplaceAll(resolved, placeholder, fmt.Sprintf("%v", val))
+		}
+	}
+	if resolved == "" || resolved == template {
+		return "default"
+	}
+	return sanitizeNamespace(resolved)
+}
+
+// sanitizeNamespace lower-cases and strips characters not allowed in a data
+// stream name (Elasticsearch requires lowercase, no spaces or '#'/':' etc.).
+func sanitizeNamespace(s string) string {
+	s = strings.ToLower(s)
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('-')
+		}
+	}
+	out := b.String()
+	if out == "" {
+		return "default"
+	}
+	return out
+}
+
+// EnsureTemplates PUTs an ILM policy and an index template for the
+// "metrics-<dataset>-*" and "logs-<dataset>-*" data streams (dataset
+// defaulting to "tf.telemetry"). Both are written unconditionally, replacing
+// any existing policy or template of the same name, so it is safe to call on
+// every startup. It creates no component templates.
+func EnsureTemplates(ctx context.Context, es *elasticsearch.Client, cfg config.DataStreamConfig) error {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	dataset := cfg.Dataset
+	if dataset == "" {
+		dataset = "tf.telemetry"
+	}
+
+	for _, signal := range []string{"metrics", "logs"} {
+		policyName := fmt.Sprintf("%s-%s-ilm-policy", signal, dataset)
+		if err := ensureILMPolicy(ctx, es, policyName, cfg.ILM); err != nil {
+			return fmt.Errorf("ensure ILM policy %s: %w", policyName, err)
+		}
+		templateName := fmt.Sprintf("%s-%s", signal, dataset)
+		if err := ensureIndexTemplate(ctx, es, templateName, signal, dataset, policyName); err != nil {
+			return fmt.Errorf("ensure index template %s: %w", templateName, err)
+		}
+	}
+	return nil
+}
+
+func ensureILMPolicy(ctx context.Context, es *elasticsearch.Client, name string, ilm config.ILMPolicyConfig) error {
+	hotMaxAge := ilm.HotMaxAge
+	if hotMaxAge == "" {
+		hotMaxAge = "1d"
+	}
+	warmMinAge := ilm.WarmMinAge
+	if warmMinAge == "" {
+		warmMinAge = "3d"
+	}
+	deleteMinAge := ilm.DeleteMinAge
+	if deleteMinAge == "" {
+		deleteMinAge = "30d"
+	}
+
+	body := fmt.Sprintf(`{
+		"policy": {
+			"phases": {
+				"hot": {"min_age": "0ms", "actions": {"rollover": {"max_age": %q}}},
+				"warm": {"min_age": %q, "actions": {}},
+				"delete": {"min_age": %q, "actions": {"delete": {}}}
+			}
+		}
+	}`, hotMaxAge, warmMinAge, deleteMinAge)
+
+	res, err := es.ILM.PutLifecycle(name, es.ILM.PutLifecycle.WithContext(ctx), es.ILM.PutLifecycle.WithBody(strings.NewReader(body)))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("ILM PutLifecycle failed: %s", res.Status())
+	}
+	return nil
+}
+
+func ensureIndexTemplate(ctx context.Context, es *elasticsearch.Client, name, signal, dataset, policyName string) error {
+	body := fmt.Sprintf(`{
+		"index_patterns": ["%s-%s-*"],
+		"data_stream": {},
+		"template": {
+			"settings": {"index.lifecycle.name": %q}
+		}
+	}`, signal, dataset, policyName)
+
+	res, err := es.Indices.PutIndexTemplate(name, strings.NewReader(body), es.Indices.PutIndexTemplate.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("PutIndexTemplate failed: %s", res.Status())
+	}
+	return nil
+}
@@ -0,0 +1,564 @@
+package indexer
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+
+	"github.com/threatfabric-devops/tf-telemetry/internal/metrics"
+)
+
+// ErrQueueFull is returned by Add when the durable queue's backlog has
+// reached DurableOptions.MaxQueueBytes. Callers should surface this as
+// back-pressure to their own caller rather than retrying indefinitely.
+var ErrQueueFull = errors.New("durable queue: max_queue_bytes exceeded")
+
+// queueMetricsInterval controls how often the background reporter goroutine
+// refreshes the depth/oldest-age gauges.
+const queueMetricsInterval = 2 * time.Second
+
+// DurableOptions configures the on-disk write-ahead queue fronting an
+// esutil.BulkIndexer.
+type DurableOptions struct {
+	// MaxBytes rotates the active segment once it exceeds this size. Zero
+	// disables rotation (a single growing segment file).
+	MaxBytes int64
+	// FsyncInterval is the minimum time between fsyncs of the active
+	// segment: Add fsyncs inline once at least this long has passed since
+	// the previous fsync. Zero fsyncs after every record.
+	FsyncInterval time.Duration
+	// DeadLetterDir holds records that failed with a non-retryable status.
+	// Defaults to "<dir>/dead-letter".
+	DeadLetterDir string
+	// MaxQueueBytes caps the total size of records not yet delivered to inner
+	// (across all segments, past the checkpoint). Add returns ErrQueueFull
+	// once reached. Zero disables the cap.
+	MaxQueueBytes int64
+}
+
+const (
+	segmentPrefix   = "segment-"
+	segmentSuffix   = ".log"
+	checkpointFile  = "checkpoint"
+	deadLetterFile  = "dead-letter.log"
+	recordHeaderLen = 4 + 4 + 1 + 2 + 8 // bodyLen + crc + action byte + indexLen + enqueuedAtUnixMilli
+)
+
+// durableRecord is one queued bulk item. On disk it is a recordHeaderLen-byte
+// header {bodyLen uint32, crc32(body) uint32, action byte, indexLen uint16,
+// enqueuedAtUnixMilli uint64} followed by the index name and the JSON body.
+type durableRecord struct {
+	Index  string
+	Action string
+	Body   []byte
+	// EnqueuedAtUnixMilli is when Add wrote this record to the WAL, used to
+	// report the oldest-undelivered-record age gauge.
+	EnqueuedAtUnixMilli int64
+}
+
+// Durable is an esutil.BulkIndexer-compatible wrapper that persists items to
+// a segmented append-only log before handing them to inner, so telemetry
+// survives Elasticsearch outages and process restarts.
+type Durable struct {
+	dir   string
+	inner esutil.BulkIndexer
+	opts  DurableOptions
+
+	mu         sync.Mutex
+	writer     *os.File
+	writerSize int64
+	writerSeg  int
+	lastFsync  time.Time
+
+	checkpoint checkpointState
+
+	// queuedBytes tracks the on-disk size of records not yet delivered to
+	// inner, and oldestPendingMillis the enqueue time of the record at the
+	// head of the queue (zero when the queue is empty); both back the
+	// tf_telemetry_queue_* gauges and, for queuedBytes, MaxQueueBytes
+	// enforcement.
+	queuedBytes         int64
+	oldestPendingMillis int64
+
+	closeOnce sync.Once
+	closeCh   chan struct{}
+	wg        sync.WaitGroup
+}
+
+type checkpointState struct {
+	Segment int   `json:"segment"`
+	Offset  int64 `json:"offset"`
+}
+
+// NewDurable opens (or creates) the WAL under dir and starts the drainer
+// goroutine that streams unflushed records into inner.
+func NewDurable(dir string, inner esutil.BulkIndexer, opts DurableOptions) (*Durable, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create queue dir: %w", err)
+	}
+	if opts.DeadLetterDir == "" {
+		opts.DeadLetterDir = filepath.Join(dir, "dead-letter")
+	}
+	if err := os.MkdirAll(opts.DeadLetterDir, 0o755); err != nil {
+		return nil, fmt.Errorf("create dead letter dir: %w", err)
+	}
+
+	d := &Durable{
+		dir:     dir,
+		inner:   inner,
+		opts:    opts,
+		closeCh: make(chan struct{}),
+	}
+
+	cp, err := loadCheckpoint(checkpointPath(dir))
+	if err != nil {
+		return nil, fmt.Errorf("load checkpoint: %w", err)
+	}
+	d.checkpoint = cp
+
+	seg, err := latestSegment(dir)
+	if err != nil {
+		return nil, fmt.Errorf("scan segments: %w", err)
+	}
+	if seg < cp.Segment {
+		seg = cp.Segment
+	}
+	if err := d.openWriter(seg); err != nil {
+		return nil, err
+	}
+
+	backlog, err := pendingBytesOnDisk(dir, cp)
+	if err != nil {
+		return nil, fmt.Errorf("scan pending backlog: %w", err)
+	}
+	atomic.StoreInt64(&d.queuedBytes, backlog)
+
+	d.wg.Add(1)
+	go d.drain()
+
+	d.wg.Add(1)
+	go d.reportMetrics()
+
+	return d, nil
+}
+
+// pendingBytesOnDisk sums the bytes of every segment record not yet past the
+// checkpoint, so a restart starts the depth gauge (and MaxQueueBytes
+// enforcement) from the true on-disk backlog rather than zero.
+func pendingBytesOnDisk(dir string, cp checkpointState) (int64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, e := range entries {
+		var seg int
+		if _, err := fmt.Sscanf(e.Name(), segmentPrefix+"%08d"+segmentSuffix, &seg); err != nil {
+			continue
+		}
+		if seg < cp.Segment {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return 0, err
+		}
+		if seg == cp.Segment {
+			total += info.Size() - cp.Offset
+			continue
+		}
+		total += info.Size()
+	}
+	if total < 0 {
+		total = 0
+	}
+	return total, nil
+}
+
+// Add appends item to the WAL and returns once it is written to the active
+// segment; the segment is fsynced before Add returns only when FsyncInterval
+// is zero or has elapsed since the previous fsync. The actual Elasticsearch
+// write happens asynchronously in the drainer goroutine.
+func (d *Durable) Add(_ context.Context, item esutil.BulkIndexerItem) error {
+	body, err := io.ReadAll(item.Body)
+	if err != nil {
+		return fmt.Errorf("read item body: %w", err)
+	}
+	return d.append(durableRecord{
+		Index:               item.Index,
+		Action:              item.Action,
+		Body:                body,
+		EnqueuedAtUnixMilli: time.Now().UnixMilli(),
+	})
+}
+
+// Close stops the drainer goroutine and closes the active segment. It does
+// not flush inner; callers should close inner themselves afterwards.
+func (d *Durable) Close(_ context.Context) error {
+	d.closeOnce.Do(func() { close(d.closeCh) })
+	d.wg.Wait()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.writer != nil {
+		return d.writer.Close()
+	}
+	return nil
+}
+
+// Stats proxies the inner indexer's stats; the WAL itself does not track
+// per-document counters.
+func (d *Durable) Stats() esutil.BulkIndexerStats {
+	return d.inner.Stats()
+}
+
+func (d *Durable) append(rec durableRecord) error {
+	buf := encodeRecord(rec)
+
+	if d.opts.MaxQueueBytes > 0 && atomic.LoadInt64(&d.queuedBytes)+int64(len(buf)) > d.opts.MaxQueueBytes {
+		return ErrQueueFull
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.opts.MaxBytes > 0 && d.writerSize >= d.opts.MaxBytes {
+		if err := d.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := d.writer.Write(buf)
+	if err != nil {
+		return fmt.Errorf("write record: %w", err)
+	}
+	d.writerSize += int64(n)
+	atomic.AddInt64(&d.queuedBytes, int64(n))
+
+	if d.opts.FsyncInterval <= 0 || time.Since(d.lastFsync) >= d.opts.FsyncInterval {
+		if err := d.writer.Sync(); err != nil {
+			return fmt.Errorf("fsync segment: %w", err)
+		}
+		d.lastFsync = time.Now()
+	}
+	return nil
+}
+
+func (d *Durable) rotate() error {
+	if err := d.writer.Close(); err != nil {
+		return err
+	}
+	return d.openWriter(d.writerSeg + 1)
+}
+
+func (d *Durable) openWriter(seg int) error {
+	f, err := os.OpenFile(segmentPath(d.dir, seg), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open segment %d: %w", seg, err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	d.writer = f
+	d.writerSeg = seg
+	d.writerSize = info.Size()
+	return nil
+}
+
+// drain streams records starting at the last checkpoint into inner,
+// advancing the checkpoint only after a successful (or dead-lettered) write.
+func (d *Durable) drain() {
+	defer d.wg.Done()
+
+	seg := d.checkpoint.Segment
+	offset := d.checkpoint.Offset
+	backoff := 100 * time.Millisecond
+
+	for {
+		select {
+		case <-d.closeCh:
+			return
+		default:
+		}
+
+		path := segmentPath(d.dir, seg)
+		rec, next, err := readRecordAt(path, offset)
+		if err == io.EOF {
+			if seg < d.currentSegment() {
+				seg++
+				offset = 0
+				continue
+			}
+			atomic.StoreInt64(&d.oldestPendingMillis, 0)
+			if d.sleep(200 * time.Millisecond) {
+				return
+			}
+			continue
+		}
+		if err != nil {
+			// Corrupt record: skip it rather than stall the queue forever.
+			d.dequeueBytes(next - offset)
+			offset = next
+			continue
+		}
+
+		atomic.StoreInt64(&d.oldestPendingMillis, rec.EnqueuedAtUnixMilli)
+		if err := d.indexOne(rec); err != nil {
+			if d.sleep(backoff) {
+				return
+			}
+			if backoff < 30*time.Second {
+				backoff *= 2
+			}
+			continue
+		}
+		backoff = 100 * time.Millisecond
+		d.dequeueBytes(next - offset)
+		offset = next
+		_ = saveCheckpoint(checkpointPath(d.dir), checkpointState{Segment: seg, Offset: offset})
+	}
+}
+
+// dequeueBytes releases n bytes from the queued-bytes backlog as a record
+// leaves the queue (delivered, dead-lettered, or skipped as corrupt).
+func (d *Durable) dequeueBytes(n int64) {
+	if atomic.AddInt64(&d.queuedBytes, -n) < 0 {
+		atomic.StoreInt64(&d.queuedBytes, 0)
+	}
+}
+
+// reportMetrics periodically refreshes the queue depth/oldest-age gauges
+// until Close. A ticker (rather than updating inline on every append/drain
+// step) keeps the gauges live even while the queue is idle or backed off.
+func (d *Durable) reportMetrics() {
+	defer d.wg.Done()
+
+	ticker := time.NewTicker(queueMetricsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-d.closeCh:
+			return
+		case <-ticker.C:
+			depth := atomic.LoadInt64(&d.queuedBytes)
+			var ageSeconds float64
+			if oldest := atomic.LoadInt64(&d.oldestPendingMillis); oldest > 0 {
+				ageSeconds = time.Since(time.UnixMilli(oldest)).Seconds()
+			}
+			metrics.ObserveQueueStats(depth, ageSeconds)
+		}
+	}
+}
+
+func (d *Durable) sleep(dur time.Duration) (closed bool) {
+	select {
+	case <-d.closeCh:
+		return true
+	case <-time.After(dur):
+		return false
+	}
+}
+
+func (d *Durable) currentSegment() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.writerSeg
+}
+
+// indexOne synchronously hands rec to inner and waits for its OnSuccess/
+// OnFailure callback, translating retryable ES failures (429/5xx) into an
+// error so drain() backs off and retries, and writing non-retryable
+// failures to the dead-letter segment instead of retrying forever.
+func (d *Durable) indexOne(rec durableRecord) error {
+	var (
+		wg     sync.WaitGroup
+		outErr error
+	)
+	wg.Add(1)
+	err := d.inner.Add(context.Background(), esutil.BulkIndexerItem{
+		Index:  rec.Index,
+		Action: rec.Action,
+		Body:   newReader(rec.Body),
+		OnSuccess: func(context.Context, esutil.BulkIndexerItem, esutil.BulkIndexerResponseItem) {
+			defer wg.Done()
+		},
+		OnFailure: func(_ context.Context, _ esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, failErr error) {
+			defer wg.Done()
+			if failErr != nil || isRetryableStatus(res.Status) {
+				outErr = fmt.Errorf("retryable index failure (status=%d): %v", res.Status, failErr)
+				return
+			}
+			if dlErr := d.writeDeadLetter(rec); dlErr != nil {
+				outErr = dlErr
+			}
+		},
+	})
+	if err != nil {
+		wg.Done()
+		return err
+	}
+	wg.Wait()
+	return outErr
+}
+
+func isRetryableStatus(status int) bool {
+	return status == 429 || status >= 500
+}
+
+func (d *Durable) writeDeadLetter(rec durableRecord) error {
+	path := filepath.Join(d.opts.DeadLetterDir, deadLetterFile)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open dead letter segment: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(encodeRecord(rec)); err != nil {
+		return fmt.Errorf("write dead letter record: %w", err)
+	}
+	return f.Sync()
+}
+
+func encodeRecord(rec durableRecord) []byte {
+	buf := make([]byte, recordHeaderLen+len(rec.Index)+len(rec.Body))
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(rec.Body)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(rec.Body))
+	buf[8] = actionByte(rec.Action)
+	binary.BigEndian.PutUint16(buf[9:11], uint16(len(rec.Index)))
+	binary.BigEndian.PutUint64(buf[11:19], uint64(rec.EnqueuedAtUnixMilli))
+	n := copy(buf[recordHeaderLen:], rec.Index)
+	copy(buf[recordHeaderLen+n:], rec.Body)
+	return buf
+}
+
+func actionByte(action string) byte {
+	if action == "create" {
+		return 1
+	}
+	return 0
+}
+
+func actionString(b byte) string {
+	if b == 1 {
+		return "create"
+	}
+	return "index"
+}
+
+// readRecordAt reads one record from path at offset, returning the record,
+// the offset of the next record, and io.EOF when offset is at (or past) the
+// current end of the file.
+func readRecordAt(path string, offset int64) (durableRecord, int64, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return durableRecord{}, offset, io.EOF
+	}
+	if err != nil {
+		return durableRecord{}, offset, err
+	}
+	defer f.Close()
+
+	header := make([]byte, recordHeaderLen)
+	if _, err := f.ReadAt(header, offset); err != nil {
+		return durableRecord{}, offset, io.EOF
+	}
+
+	bodyLen := binary.BigEndian.Uint32(header[0:4])
+	wantCRC := binary.BigEndian.Uint32(header[4:8])
+	action := actionString(header[8])
+	indexLen := binary.BigEndian.Uint16(header[9:11])
+	enqueuedAt := int64(binary.BigEndian.Uint64(header[11:19]))
+
+	rest := make([]byte, int(indexLen)+int(bodyLen))
+	if _, err := f.ReadAt(rest, offset+recordHeaderLen); err != nil {
+		return durableRecord{}, offset, io.EOF
+	}
+	index := string(rest[:indexLen])
+	body := rest[indexLen:]
+
+	next := offset + recordHeaderLen + int64(indexLen) + int64(bodyLen)
+	if crc32.ChecksumIEEE(body) != wantCRC {
+		return durableRecord{}, next, fmt.Errorf("corrupt record at %s:%d", path, offset)
+	}
+	return durableRecord{Index: index, Action: action, Body: body, EnqueuedAtUnixMilli: enqueuedAt}, next, nil
+}
+
+func segmentPath(dir string, seg int) string {
+	return filepath.Join(dir, fmt.Sprintf("%s%08d%s", segmentPrefix, seg, segmentSuffix))
+}
+
+func checkpointPath(dir string) string {
+	return filepath.Join(dir, checkpointFile)
+}
+
+func latestSegment(dir string) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	latest := 0
+	for _, e := range entries {
+		var seg int
+		if _, err := fmt.Sscanf(e.Name(), segmentPrefix+"%08d"+segmentSuffix, &seg); err == nil && seg > latest {
+			latest = seg
+		}
+	}
+	return latest, nil
+}
+
+func loadCheckpoint(path string) (checkpointState, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return checkpointState{}, nil
+	}
+	if err != nil {
+		return checkpointState{}, err
+	}
+	var seg int
+	var offset int64
+	if _, err := fmt.Sscanf(string(data), "%d %d", &seg, &offset); err != nil {
+		return checkpointState{}, nil
+	}
+	return checkpointState{Segment: seg, Offset: offset}, nil
+}
+
+func saveCheckpoint(path string, cp checkpointState) error {
+	tmp := path + fmt.Sprintf(".tmp%d", rand.Int())
+	if err := os.WriteFile(tmp, []byte(fmt.Sprintf("%d %d", cp.Segment, cp.Offset)), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// newReader avoids an extra import footprint for the common bytes.Reader case.
+func newReader(b []byte) io.Reader {
+	return &byteReader{b: b}
+}
+
+type byteReader struct {
+	b   []byte
+	pos int
+}
+
+func (r *byteReader) Read(p []byte) (int, error) {
+	if r.pos >= len(r.b) {
+		return 0, io.EOF
+	}
+	n := copy(p, r.b[r.pos:])
+	r.pos += n
+	return n, nil
+}
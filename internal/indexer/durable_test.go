@@ -0,0 +1,307 @@
+package indexer
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8/esutil"
+)
+
+// controlledIndexer lets tests decide, per Add call, whether the item
+// succeeds, fails retryably, or fails permanently.
+type controlledIndexer struct {
+	mu       sync.Mutex
+	indexed  []string
+	behavior func(callNum int) (status int, fail bool)
+	calls    int
+}
+
+func (c *controlledIndexer) Add(_ context.Context, item esutil.BulkIndexerItem) error {
+	data, _ := io.ReadAll(item.Body)
+
+	c.mu.Lock()
+	c.calls++
+	call := c.calls
+	c.mu.Unlock()
+
+	status, fail := 201, false
+	if c.behavior != nil {
+		status, fail = c.behavior(call)
+	}
+
+	if fail {
+		item.OnFailure(context.Background(), item, esutil.BulkIndexerResponseItem{Status: status}, nil)
+		return nil
+	}
+
+	c.mu.Lock()
+	c.indexed = append(c.indexed, string(data))
+	c.mu.Unlock()
+	item.OnSuccess(context.Background(), item, esutil.BulkIndexerResponseItem{Status: status})
+	return nil
+}
+
+func (c *controlledIndexer) Close(context.Context) error { return nil }
+
+func (c *controlledIndexer) Stats() esutil.BulkIndexerStats { return esutil.BulkIndexerStats{} }
+
+func (c *controlledIndexer) snapshot() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make([]string, len(c.indexed))
+	copy(out, c.indexed)
+	return out
+}
+
+func waitFor(t *testing.T, timeout time.Duration, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("condition not met within %s", timeout)
+}
+
+func TestDurableDrainsQueuedRecordsToInner(t *testing.T) {
+	dir := t.TempDir()
+	inner := &controlledIndexer{}
+
+	d, err := NewDurable(dir, inner, DurableOptions{})
+	if err != nil {
+		t.Fatalf("NewDurable: %v", err)
+	}
+	defer d.Close(context.Background())
+
+	for i := 0; i < 5; i++ {
+		if err := d.Add(context.Background(), esutil.BulkIndexerItem{
+			Index:  "metrics",
+			Action: "create",
+			Body:   newReader([]byte(`{"n":` + string(rune('0'+i)) + `}`)),
+		}); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	waitFor(t, 2*time.Second, func() bool { return len(inner.snapshot()) == 5 })
+}
+
+// TestDurableRecoversAfterRestartWithoutDuplication simulates an Elasticsearch
+// outage (every write fails retryably), a process restart with the WAL on
+// disk, and verifies the records are delivered exactly once once ES recovers.
+func TestDurableRecoversAfterRestartWithoutDuplication(t *testing.T) {
+	dir := t.TempDir()
+
+	down := &controlledIndexer{behavior: func(int) (int, bool) { return 503, true }}
+	d1, err := NewDurable(dir, down, DurableOptions{})
+	if err != nil {
+		t.Fatalf("NewDurable: %v", err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := d1.Add(context.Background(), esutil.BulkIndexerItem{
+			Index:  "metrics",
+			Action: "create",
+			Body:   newReader([]byte(`{"seq":` + string(rune('0'+i)) + `}`)),
+		}); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+
+	// Give the drain goroutine a chance to attempt (and fail) delivery before
+	// we "kill" the process by closing without having advanced the checkpoint.
+	time.Sleep(150 * time.Millisecond)
+	if got := down.snapshot(); len(got) != 0 {
+		t.Fatalf("expected nothing indexed while ES is down, got %v", got)
+	}
+	if err := d1.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	up := &controlledIndexer{}
+	d2, err := NewDurable(dir, up, DurableOptions{})
+	if err != nil {
+		t.Fatalf("NewDurable (restart): %v", err)
+	}
+	defer d2.Close(context.Background())
+
+	waitFor(t, 2*time.Second, func() bool { return len(up.snapshot()) == 3 })
+
+	seen := map[string]int{}
+	for _, body := range up.snapshot() {
+		seen[body]++
+	}
+	for body, count := range seen {
+		if count != 1 {
+			t.Errorf("record %q delivered %d times, want exactly once", body, count)
+		}
+	}
+}
+
+// TestDurableDoesNotRedeliverCheckpointedRecords verifies that records which
+// already succeeded before a restart are not replayed.
+func TestDurableDoesNotRedeliverCheckpointedRecords(t *testing.T) {
+	dir := t.TempDir()
+
+	inner := &controlledIndexer{}
+	d1, err := NewDurable(dir, inner, DurableOptions{})
+	if err != nil {
+		t.Fatalf("NewDurable: %v", err)
+	}
+	if err := d1.Add(context.Background(), esutil.BulkIndexerItem{
+		Index:  "metrics",
+		Action: "create",
+		Body:   newReader([]byte(`{"seq":0}`)),
+	}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	waitFor(t, 2*time.Second, func() bool { return len(inner.snapshot()) == 1 })
+	if err := d1.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	inner2 := &controlledIndexer{}
+	d2, err := NewDurable(dir, inner2, DurableOptions{})
+	if err != nil {
+		t.Fatalf("NewDurable (restart): %v", err)
+	}
+	defer d2.Close(context.Background())
+
+	if err := d2.Add(context.Background(), esutil.BulkIndexerItem{
+		Index:  "metrics",
+		Action: "create",
+		Body:   newReader([]byte(`{"seq":1}`)),
+	}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	waitFor(t, 2*time.Second, func() bool { return len(inner2.snapshot()) == 1 })
+
+	time.Sleep(100 * time.Millisecond)
+	if got := inner2.snapshot(); len(got) != 1 || got[0] != `{"seq":1}` {
+		t.Fatalf("expected only the new record to be redelivered, got %v", got)
+	}
+}
+
+func TestDurableMovesNonRetryableFailuresToDeadLetter(t *testing.T) {
+	dir := t.TempDir()
+	rejecting := &controlledIndexer{behavior: func(int) (int, bool) { return 400, true }}
+
+	d, err := NewDurable(dir, rejecting, DurableOptions{})
+	if err != nil {
+		t.Fatalf("NewDurable: %v", err)
+	}
+	defer d.Close(context.Background())
+
+	if err := d.Add(context.Background(), esutil.BulkIndexerItem{
+		Index:  "metrics",
+		Action: "create",
+		Body:   newReader([]byte(`{"bad":true}`)),
+	}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+
+	deadLetterPath := filepath.Join(dir, "dead-letter", deadLetterFile)
+	waitFor(t, 2*time.Second, func() bool {
+		info, err := os.Stat(deadLetterPath)
+		return err == nil && info.Size() > 0
+	})
+}
+
+// TestDurableAddRejectsOnceMaxQueueBytesExceeded verifies that once the
+// backlog of undelivered records reaches MaxQueueBytes, Add starts rejecting
+// new records with ErrQueueFull instead of growing the WAL unbounded.
+func TestDurableAddRejectsOnceMaxQueueBytesExceeded(t *testing.T) {
+	dir := t.TempDir()
+	down := &controlledIndexer{behavior: func(int) (int, bool) { return 503, true }}
+
+	rec := esutil.BulkIndexerItem{Index: "metrics", Action: "create", Body: newReader([]byte(`{"seq":0}`))}
+	recSize := int64(len(encodeRecord(durableRecord{Index: rec.Index, Action: rec.Action, Body: []byte(`{"seq":0}`)})))
+
+	d, err := NewDurable(dir, down, DurableOptions{MaxQueueBytes: recSize})
+	if err != nil {
+		t.Fatalf("NewDurable: %v", err)
+	}
+	defer d.Close(context.Background())
+
+	if err := d.Add(context.Background(), esutil.BulkIndexerItem{
+		Index: "metrics", Action: "create", Body: newReader([]byte(`{"seq":0}`)),
+	}); err != nil {
+		t.Fatalf("first Add should fit within the cap: %v", err)
+	}
+
+	err = d.Add(context.Background(), esutil.BulkIndexerItem{
+		Index: "metrics", Action: "create", Body: newReader([]byte(`{"seq":1}`)),
+	})
+	if !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expected ErrQueueFull once over the cap, got %v", err)
+	}
+}
+
+// TestDurableRestartRecomputesBacklogFromDisk verifies the queued-bytes
+// backlog (and thus MaxQueueBytes enforcement) survives a restart by being
+// recomputed from the on-disk segments past the checkpoint, not reset to zero.
+func TestDurableRestartRecomputesBacklogFromDisk(t *testing.T) {
+	dir := t.TempDir()
+	down := &controlledIndexer{behavior: func(int) (int, bool) { return 503, true }}
+
+	d1, err := NewDurable(dir, down, DurableOptions{})
+	if err != nil {
+		t.Fatalf("NewDurable: %v", err)
+	}
+	if err := d1.Add(context.Background(), esutil.BulkIndexerItem{
+		Index: "metrics", Action: "create", Body: newReader([]byte(`{"seq":0}`)),
+	}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	time.Sleep(150 * time.Millisecond)
+	if err := d1.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	recSize := int64(len(encodeRecord(durableRecord{Index: "metrics", Action: "create", Body: []byte(`{"seq":0}`)})))
+
+	d2, err := NewDurable(dir, down, DurableOptions{MaxQueueBytes: recSize})
+	if err != nil {
+		t.Fatalf("NewDurable (restart): %v", err)
+	}
+	defer d2.Close(context.Background())
+
+	if got := atomic.LoadInt64(&d2.queuedBytes); got != recSize {
+		t.Fatalf("expected backlog recomputed from disk to be %d, got %d", recSize, got)
+	}
+
+	err = d2.Add(context.Background(), esutil.BulkIndexerItem{
+		Index: "metrics", Action: "create", Body: newReader([]byte(`{"seq":1}`)),
+	})
+	if !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expected ErrQueueFull given the recovered backlog already fills the cap, got %v", err)
+	}
+}
+
+func TestEncodeRecordRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	rec := durableRecord{Index: "logs", Action: "create", Body: []byte(`{"hello":"world"}`)}
+	if err := os.WriteFile(filepath.Join(dir, "segment-00000000.log"), encodeRecord(rec), 0o644); err != nil {
+		t.Fatalf("write segment: %v", err)
+	}
+
+	got, next, err := readRecordAt(filepath.Join(dir, "segment-00000000.log"), 0)
+	if err != nil {
+		t.Fatalf("readRecordAt: %v", err)
+	}
+	if got.Index != rec.Index || got.Action != rec.Action || string(got.Body) != string(rec.Body) {
+		t.Fatalf("round trip mismatch: got %+v, want %+v", got, rec)
+	}
+	if next != int64(len(encodeRecord(rec))) {
+		t.Fatalf("unexpected next offset: %d", next)
+	}
+}
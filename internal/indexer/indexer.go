@@ -1,6 +1,7 @@
 package indexer
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -37,5 +38,25 @@ func New(cfg *config.Config, logger *zap.Logger) (*elasticsearch.Client, esutil.
 		return nil, nil, fmt.Errorf("error creating bulk indexer: %w", err)
 	}
 
-	return es, bi, nil
+	if cfg.Elastic.DataStreams.Enabled {
+		if err := EnsureTemplates(context.Background(), es, cfg.Elastic.DataStreams); err != nil {
+			logger.Warn("failed to ensure data stream templates", zap.Error(err))
+		}
+	}
+
+	var bulkIndexer esutil.BulkIndexer = bi
+	if cfg.Elastic.Queue.Enabled {
+		durable, err := NewDurable(cfg.Elastic.Queue.Dir, bi, DurableOptions{
+			MaxBytes:      cfg.Elastic.Queue.MaxBytes,
+			FsyncInterval: time.Duration(cfg.Elastic.Queue.FsyncIntervalMs) * time.Millisecond,
+			DeadLetterDir: cfg.Elastic.Queue.DeadLetterDir,
+			MaxQueueBytes: cfg.Elastic.Queue.MaxQueueBytes,
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("error creating durable queue: %w", err)
+		}
+		bulkIndexer = durable
+	}
+
+	return es, bulkIndexer, nil
 }
@@ -10,7 +10,8 @@ import (
 type NullBulkIndexer struct{}
 
 func (n *NullBulkIndexer) Add(ctx context.Context, item esutil.BulkIndexerItem) error {
-	// No-op: just return success without actually indexing
+	// No-op regardless of item.Action: accepts both classic "index" items and
+	// the "create" actions used for data streams.
 	return nil
 }
 
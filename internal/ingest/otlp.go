@@ -0,0 +1,42 @@
+package ingest
+
+import (
+	"context"
+
+	logspb "go.opentelemetry.io/proto/otlp/collector/logs/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+
+	"github.com/threatfabric-devops/tf-telemetry/internal/otlp"
+)
+
+// SendOTLPMetrics indexes a native OTLP ExportMetricsServiceRequest using the
+// same bulk indexer as SendTelemetry.
+func (s *Sender) SendOTLPMetrics(ctx context.Context, req *metricspb.ExportMetricsServiceRequest) (*metricspb.ExportMetricsServiceResponse, error) {
+	for _, doc := range otlp.TranslateMetrics(req.GetResourceMetrics(), s.cfg.OTLP) {
+		target, action := s.router.Route("metrics", doc)
+		if err := s.indexAsync(ctx, target, action, doc); err != nil {
+			return nil, err
+		}
+	}
+	return &metricspb.ExportMetricsServiceResponse{}, nil
+}
+
+// SendOTLPLogs indexes a native OTLP ExportLogsServiceRequest using the same
+// bulk indexer as SendTelemetry.
+func (s *Sender) SendOTLPLogs(ctx context.Context, req *logspb.ExportLogsServiceRequest) (*logspb.ExportLogsServiceResponse, error) {
+	for _, doc := range otlp.TranslateLogs(req.GetResourceLogs(), s.cfg.OTLP) {
+		target, action := s.router.Route("logs", doc)
+		if err := s.indexAsync(ctx, target, action, doc); err != nil {
+			return nil, err
+		}
+	}
+	return &logspb.ExportLogsServiceResponse{}, nil
+}
+
+// SendOTLPTraces accepts a native OTLP ExportTraceServiceRequest. Trace
+// indexing is not yet wired to a dedicated ES target; it is accepted and
+// acknowledged so SDKs configured to export all three signals don't error.
+func (s *Sender) SendOTLPTraces(ctx context.Context, req *tracepb.ExportTraceServiceRequest) (*tracepb.ExportTraceServiceResponse, error) {
+	return &tracepb.ExportTraceServiceResponse{}, nil
+}
@@ -0,0 +1,227 @@
+package ingest
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"sync"
+
+	"github.com/threatfabric-devops/tf-telemetry/internal/config"
+)
+
+// aggregatedFields are the numeric metric document fields rolled up by the
+// sampler. "memory." is a prefix: every key under the doc's "memory" object
+// is aggregated individually.
+const (
+	fieldBatteryLevel = "battery_level"
+	fieldCPUTotal     = "cpu.total_usage_percent"
+	memoryFieldPrefix = "memory."
+)
+
+// sampler probabilistically drops metric points by installation_id and
+// rolls the rest up into fixed-width time buckets, per config.SamplingConfig.
+// A nil *sampler means raw passthrough; callers must check for nil.
+type sampler struct {
+	cfg config.SamplingConfig
+
+	mu      sync.Mutex
+	buckets map[bucketKey]*bucketAgg
+}
+
+type bucketKey struct {
+	installationID string
+	journeyID      string
+	network        string
+	bucketStart    int64
+}
+
+type bucketAgg struct {
+	metadata map[string]any
+	fields   map[string]*fieldAgg
+}
+
+type fieldAgg struct {
+	count  float64
+	sum    float64
+	min    float64
+	max    float64
+	digest *tDigest
+}
+
+func newFieldAgg() *fieldAgg {
+	return &fieldAgg{min: math.Inf(1), max: math.Inf(-1), digest: newTDigest(100)}
+}
+
+func (f *fieldAgg) add(v float64) {
+	f.count++
+	f.sum += v
+	if v < f.min {
+		f.min = v
+	}
+	if v > f.max {
+		f.max = v
+	}
+	f.digest.Add(v)
+}
+
+func (f *fieldAgg) snapshot(quantiles []float64) map[string]any {
+	out := map[string]any{
+		"count": f.count,
+		"sum":   f.sum,
+		"min":   f.min,
+		"max":   f.max,
+		"avg":   f.sum / f.count,
+	}
+	for _, q := range quantiles {
+		out[fmt.Sprintf("p%d", int(q*100))] = f.digest.Quantile(q)
+	}
+	return out
+}
+
+// newSampler returns nil when sampling is disabled, so callers can fall
+// back to raw passthrough without a branch on cfg.Enabled everywhere.
+func newSampler(cfg config.SamplingConfig) *sampler {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &sampler{cfg: cfg, buckets: map[bucketKey]*bucketAgg{}}
+}
+
+// shouldDrop reports whether a point for installationID should be dropped
+// outright, based on a stable hash of the installation ID so the same
+// installation is sampled consistently.
+func (s *sampler) shouldDrop(installationID string) bool {
+	if s.cfg.InstallationHashRate <= 0 {
+		return false
+	}
+	return hashUnit(installationID) < s.cfg.InstallationHashRate
+}
+
+// add folds doc's numeric fields into the bucket for its
+// (installation_id, journey_id, network) key and the time bucket its own
+// "timestamp" (milliseconds) falls in.
+func (s *sampler) add(doc map[string]any) {
+	installationID, _ := doc["installation_id"].(string)
+	journeyID, _ := doc["journey_id"].(string)
+	network, _ := doc["network"].(string)
+
+	bucketSeconds := int64(s.cfg.MetricsBucketSeconds)
+	if bucketSeconds <= 0 {
+		bucketSeconds = 60
+	}
+	var tsMillis float64
+	if v, ok := toFloat(doc["timestamp"]); ok {
+		tsMillis = v
+	}
+	bucketStart := (int64(tsMillis) / 1000 / bucketSeconds) * bucketSeconds
+
+	key := bucketKey{
+		installationID: installationID,
+		journeyID:      journeyID,
+		network:        network,
+		bucketStart:    bucketStart,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucketAgg{
+			metadata: map[string]any{
+				"installation_id":  installationID,
+				"journey_id":       journeyID,
+				"network":          network,
+				"platform":         doc["platform"],
+				"sdk_version":      doc["sdk_version"],
+				"host_app_version": doc["host_app_version"],
+				"host_app_name":    doc["host_app_name"],
+				"bucket_start":     bucketStart,
+				"bucket_seconds":   bucketSeconds,
+			},
+			fields: map[string]*fieldAgg{},
+		}
+		s.buckets[key] = b
+	}
+
+	for field, v := range extractNumericFields(doc) {
+		fa, ok := b.fields[field]
+		if !ok {
+			fa = newFieldAgg()
+			b.fields[field] = fa
+		}
+		fa.add(v)
+	}
+}
+
+// flush emits one aggregated document per open bucket and resets the
+// sampler for the next interval.
+func (s *sampler) flush() []map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.buckets) == 0 {
+		return nil
+	}
+
+	docs := make([]map[string]any, 0, len(s.buckets))
+	for _, b := range s.buckets {
+		doc := make(map[string]any, len(b.metadata)+len(b.fields))
+		for k, v := range b.metadata {
+			doc[k] = v
+		}
+		for field, fa := range b.fields {
+			doc[field] = fa.snapshot(s.cfg.Quantiles)
+		}
+		docs = append(docs, doc)
+	}
+	s.buckets = map[bucketKey]*bucketAgg{}
+	return docs
+}
+
+func hashUnit(s string) float64 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(s))
+	return float64(h.Sum32()) / float64(math.MaxUint32)
+}
+
+func extractNumericFields(doc map[string]any) map[string]float64 {
+	out := map[string]float64{}
+	if v, ok := toFloat(doc[fieldBatteryLevel]); ok {
+		out[fieldBatteryLevel] = v
+	}
+	if cpu, ok := doc["cpu"].(map[string]any); ok {
+		if v, ok := toFloat(cpu["total_usage_percent"]); ok {
+			out[fieldCPUTotal] = v
+		}
+	}
+	if memory, ok := doc["memory"].(map[string]any); ok {
+		for k, raw := range memory {
+			if v, ok := toFloat(raw); ok {
+				out[memoryFieldPrefix+k] = v
+			}
+		}
+	}
+	return out
+}
+
+func toFloat(v any) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,101 @@
+package ingest
+
+import (
+	"math"
+	"testing"
+
+	"github.com/threatfabric-devops/tf-telemetry/internal/config"
+)
+
+func TestTDigestQuantiles(t *testing.T) {
+	d := newTDigest(100)
+	for i := 1; i <= 100; i++ {
+		d.Add(float64(i))
+	}
+
+	if got := d.Quantile(0.5); math.Abs(got-50) > 5 {
+		t.Errorf("p50 = %v, want close to 50", got)
+	}
+	if got := d.Quantile(0.99); got < 90 {
+		t.Errorf("p99 = %v, want close to 99..100", got)
+	}
+}
+
+func TestSamplerAggregatesBucketAndFlushes(t *testing.T) {
+	s := newSampler(config.SamplingConfig{
+		Enabled:              true,
+		MetricsBucketSeconds: 60,
+		Quantiles:            []float64{0.5, 0.95},
+	})
+
+	base := map[string]any{
+		"installation_id": "inst-1",
+		"journey_id":      "journey-1",
+		"network":         "WIFI",
+		"timestamp":       float64(0),
+		"battery_level":   float64(80),
+		"cpu":             map[string]any{"total_usage_percent": float64(10)},
+	}
+	other := map[string]any{
+		"installation_id": "inst-1",
+		"journey_id":      "journey-1",
+		"network":         "WIFI",
+		"timestamp":       float64(30_000),
+		"battery_level":   float64(60),
+		"cpu":             map[string]any{"total_usage_percent": float64(20)},
+	}
+
+	s.add(base)
+	s.add(other)
+
+	docs := s.flush()
+	if len(docs) != 1 {
+		t.Fatalf("expected 1 aggregated bucket, got %d", len(docs))
+	}
+
+	doc := docs[0]
+	battery, ok := doc[fieldBatteryLevel].(map[string]any)
+	if !ok {
+		t.Fatalf("expected battery_level aggregate, got %#v", doc[fieldBatteryLevel])
+	}
+	if count := battery["count"]; count != float64(2) {
+		t.Errorf("battery count = %v, want 2", count)
+	}
+	if sum := battery["sum"]; sum != float64(140) {
+		t.Errorf("battery sum = %v, want 140", sum)
+	}
+
+	if len(s.flush()) != 0 {
+		t.Error("flush should reset buckets")
+	}
+}
+
+func TestSamplerSeparatesDifferentBuckets(t *testing.T) {
+	s := newSampler(config.SamplingConfig{Enabled: true, MetricsBucketSeconds: 60})
+
+	s.add(map[string]any{
+		"installation_id": "inst-1", "journey_id": "j-1", "network": "WIFI",
+		"timestamp": float64(0), "battery_level": float64(50),
+	})
+	s.add(map[string]any{
+		"installation_id": "inst-1", "journey_id": "j-1", "network": "WIFI",
+		"timestamp": float64(120_000), "battery_level": float64(50),
+	})
+
+	docs := s.flush()
+	if len(docs) != 2 {
+		t.Fatalf("expected points in different time buckets to stay separate, got %d bucket(s)", len(docs))
+	}
+}
+
+func TestSamplerShouldDropIsStablePerInstallation(t *testing.T) {
+	s := newSampler(config.SamplingConfig{Enabled: true, InstallationHashRate: 1})
+	if !s.shouldDrop("inst-1") {
+		t.Error("hash rate of 1 should drop every installation")
+	}
+
+	none := newSampler(config.SamplingConfig{Enabled: true, InstallationHashRate: 0})
+	if none.shouldDrop("inst-1") {
+		t.Error("hash rate of 0 should never drop")
+	}
+}
@@ -6,7 +6,10 @@ import (
 	"context"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/elastic/go-elasticsearch/v8/esutil"
 	"github.com/google/uuid"
@@ -17,6 +20,11 @@ import (
 
 	"github.com/threatfabric-devops/tf-telemetry/internal/config"
 	"github.com/threatfabric-devops/tf-telemetry/internal/gen/pb"
+	"github.com/threatfabric-devops/tf-telemetry/internal/healthz"
+	"github.com/threatfabric-devops/tf-telemetry/internal/indexer"
+	"github.com/threatfabric-devops/tf-telemetry/internal/metrics"
+	"github.com/threatfabric-devops/tf-telemetry/internal/middleware"
+	"github.com/threatfabric-devops/tf-telemetry/internal/scrubber"
 )
 
 // Sender converts telemetry packets into documents and forwards them to a bulk indexer.
@@ -24,20 +32,129 @@ type Sender struct {
 	logger      *zap.Logger
 	bulkIndexer esutil.BulkIndexer
 	cfg         *config.Config
+	router      indexer.Router
+	sampler     *sampler
+	scrubber    *scrubber.Scrubber
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+	wg       sync.WaitGroup
 }
 
-// NewSender returns a Sender instance.
-func NewSender(logger *zap.Logger, bi esutil.BulkIndexer, cfg *config.Config) *Sender {
-	return &Sender{
+// NewSender returns a Sender instance. When cfg.Ingest.Sampling is enabled, a
+// background goroutine periodically flushes aggregated metric buckets; call
+// Close to stop it. An error from building the (optional) PII scrubber fails
+// NewSender outright rather than starting the collector with scrubbing
+// silently disabled: Ingest.Scrubber.Enabled is an operator's explicit
+// compliance requirement, so a bad rules file or hmac_key_hex must block
+// startup instead of shipping unredacted PII to Elasticsearch.
+func NewSender(logger *zap.Logger, bi esutil.BulkIndexer, cfg *config.Config) (*Sender, error) {
+	scrub, err := scrubber.New(logger, cfg.Ingest.Scrubber)
+	if err != nil {
+		return nil, fmt.Errorf("init PII scrubber: %w", err)
+	}
+
+	s := &Sender{
 		logger:      logger,
 		bulkIndexer: bi,
 		cfg:         cfg,
+		router:      indexer.NewRouter(cfg),
+		sampler:     newSampler(cfg.Ingest.Sampling),
+		scrubber:    scrub,
+		stopCh:      make(chan struct{}),
+	}
+
+	if s.sampler != nil {
+		bucketSeconds := cfg.Ingest.Sampling.MetricsBucketSeconds
+		if bucketSeconds <= 0 {
+			bucketSeconds = 60
+		}
+		s.wg.Add(1)
+		go s.runSamplingFlush(time.Duration(bucketSeconds) * time.Second)
+	}
+
+	return s, nil
+}
+
+// SetRouter overrides the indexer.Router used to resolve a document's target
+// index/data stream and bulk action, in place of the default
+// indexer.DataStreamRouter built from cfg.Elastic.DataStreams. Callers
+// wanting routing logic beyond NamespaceTemplate's field-substitution (e.g.
+// a lookup against an external customer directory) can call this right
+// after NewSender, before the first SendTelemetry.
+func (s *Sender) SetRouter(r indexer.Router) {
+	s.router = r
+}
+
+// RegisterHealthChecks registers s's bulk-indexer check with hc, so the
+// server's /healthz report includes the sink telemetry actually ends up in.
+// The check is non-critical: it reports unhealthy once the bulk indexer's
+// cumulative failure ratio crosses 50% over a meaningful sample, but doesn't
+// fail hc.Ready, since a degraded-but-still-flushing indexer shouldn't pull
+// the collector out of its load balancer. Call this right after NewSender,
+// like SetRouter.
+func (s *Sender) RegisterHealthChecks(hc *healthz.HealthChecker) {
+	if hc == nil {
+		return
+	}
+	const minSamples = 20
+	hc.Register(healthz.Check{
+		Name:     "bulk_indexer",
+		Interval: 30 * time.Second,
+		Check: func(context.Context) error {
+			stats := s.bulkIndexer.Stats()
+			if stats.NumAdded < minSamples {
+				return nil
+			}
+			if ratio := float64(stats.NumFailed) / float64(stats.NumAdded); ratio > 0.5 {
+				return fmt.Errorf("bulk indexer failure ratio %.0f%% over %d requests", ratio*100, stats.NumAdded)
+			}
+			return nil
+		},
+	})
+}
+
+// Close stops the background sampling flush goroutine, if any, flushing any
+// remaining aggregated buckets first, and stops the scrubber's rules watcher.
+func (s *Sender) Close() {
+	s.stopOnce.Do(func() { close(s.stopCh) })
+	s.wg.Wait()
+	s.scrubber.Close()
+}
+
+func (s *Sender) runSamplingFlush(interval time.Duration) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.flushSampledMetrics(context.Background())
+		case <-s.stopCh:
+			s.flushSampledMetrics(context.Background())
+			return
+		}
+	}
+}
+
+func (s *Sender) flushSampledMetrics(ctx context.Context) {
+	for _, doc := range s.sampler.flush() {
+		target, action := s.router.Route("metrics", doc)
+		if err := s.indexAsync(ctx, target, action, doc); err != nil {
+			// This runs off the periodic flush goroutine, with no caller to
+			// signal back-pressure to; the durable queue's own metrics and
+			// logging are the operator-visible signal here.
+			s.logger.Warn("dropped sampled metric bucket", zap.Error(err))
+		}
 	}
 }
 
 // SendTelemetry indexes incoming telemetry data and returns an acknowledgement.
 func (s *Sender) SendTelemetry(ctx context.Context, packet *pb.TelemetryPacket) (*pb.Ack, error) {
 	if packet == nil || packet.Metadata == nil {
+		metrics.PacketsTotal.WithLabelValues("rejected").Inc()
+		metrics.ValidationErrorsTotal.WithLabelValues("metadata").Inc()
 		return nil, status.Error(codes.InvalidArgument, "missing metadata")
 	}
 
@@ -48,23 +165,49 @@ func (s *Sender) SendTelemetry(ctx context.Context, packet *pb.TelemetryPacket)
 	}
 	if err := validatePacketSize(packet, maxPacketSize); err != nil {
 		s.logger.Warn("packet size exceeded", zap.Error(err))
+		metrics.PacketsTotal.WithLabelValues("rejected").Inc()
+		metrics.ValidationErrorsTotal.WithLabelValues("packet_size").Inc()
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
 	// Validate UUIDs
 	if err := validateUUIDv7(packet.Metadata.GetInstallationId(), "installation_id"); err != nil {
 		s.logger.Warn("invalid installation_id", zap.Error(err))
+		metrics.PacketsTotal.WithLabelValues("rejected").Inc()
+		metrics.ValidationErrorsTotal.WithLabelValues("installation_id").Inc()
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 	if err := validateUUIDv7(packet.Metadata.GetJourneyId(), "journey_id"); err != nil {
 		s.logger.Warn("invalid journey_id", zap.Error(err))
+		metrics.PacketsTotal.WithLabelValues("rejected").Inc()
+		metrics.ValidationErrorsTotal.WithLabelValues("journey_id").Inc()
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 
+	metrics.PacketSizeBytes.Observe(float64(proto.Size(packet)))
+
+	principal, hasPrincipal := middleware.PrincipalFromContext(ctx)
+
 	if packet.Metrics != nil {
 		for _, point := range packet.Metrics.Points {
 			doc := metricDocument(packet.Metadata, point)
-			s.indexAsync(ctx, s.cfg.Elastic.IndexMetrics, doc)
+			if hasPrincipal {
+				doc["principal"] = principal
+			}
+
+			if s.sampler != nil {
+				if s.sampler.shouldDrop(doc["installation_id"].(string)) {
+					continue
+				}
+				s.sampler.add(doc)
+				continue
+			}
+
+			target, action := s.router.Route("metrics", doc)
+			if err := s.indexAsync(ctx, target, action, doc); err != nil {
+				metrics.PacketsTotal.WithLabelValues("rejected").Inc()
+				return nil, err
+			}
 		}
 	}
 
@@ -73,23 +216,33 @@ func (s *Sender) SendTelemetry(ctx context.Context, packet *pb.TelemetryPacket)
 		if maxContextAttrs == 0 {
 			maxContextAttrs = 6 // default
 		}
-		
+
 		for _, entry := range packet.Logs.Entries {
 			// Validate context attributes before processing
 			if len(entry.GetContext()) > maxContextAttrs {
 				s.logger.Warn("log entry context exceeds maximum attributes",
 					zap.Int("count", len(entry.GetContext())),
 					zap.Int("max", maxContextAttrs))
+				metrics.PacketsTotal.WithLabelValues("rejected").Inc()
+				metrics.ValidationErrorsTotal.WithLabelValues("log_context_attrs").Inc()
 				return nil, status.Errorf(codes.InvalidArgument,
 					"log entry context has %d attributes, maximum allowed is %d",
 					len(entry.GetContext()), maxContextAttrs)
 			}
-			
+
 			doc := logDocument(packet.Metadata, entry)
-			s.indexAsync(ctx, s.cfg.Elastic.IndexLogs, doc)
+			if hasPrincipal {
+				doc["principal"] = principal
+			}
+			target, action := s.router.Route("logs", doc)
+			if err := s.indexAsync(ctx, target, action, doc); err != nil {
+				metrics.PacketsTotal.WithLabelValues("rejected").Inc()
+				return nil, err
+			}
 		}
 	}
 
+	metrics.PacketsTotal.WithLabelValues("accepted").Inc()
 	return &pb.Ack{Success: true, Message: "Accepted"}, nil
 }
 
@@ -156,18 +309,31 @@ func logDocument(metadata *pb.ClientMetadata, entry *pb.LogEntry) map[string]any
 	}
 }
 
-func (s *Sender) indexAsync(ctx context.Context, index string, doc map[string]any) {
+// indexAsync scrubs doc of PII (see internal/scrubber), then hands it to the
+// bulk indexer (or, when a durable queue fronts it, appends it to the WAL)
+// and returns once that's durable - the actual Elasticsearch write happens
+// asynchronously via OnSuccess/OnFailure. It returns a codes.ResourceExhausted
+// error when the durable queue's max_queue_bytes limit rejects the append,
+// so callers can surface real back-pressure instead of silently dropping the
+// document.
+func (s *Sender) indexAsync(ctx context.Context, index, action string, doc map[string]any) error {
+	doc = s.scrubber.Scrub(doc)
 	data, err := json.Marshal(doc)
 	if err != nil {
 		s.logger.Error("Failed to marshal document", zap.Error(err))
-		return
+		return nil
 	}
 
+	start := time.Now()
 	err = s.bulkIndexer.Add(ctx, esutil.BulkIndexerItem{
-		Action: "index",
+		Action: action,
 		Index:  index,
 		Body:   bytes.NewReader(data),
+		OnSuccess: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem) {
+			metrics.DocsIndexedTotal.WithLabelValues(item.Index, "success").Inc()
+		},
 		OnFailure: func(ctx context.Context, item esutil.BulkIndexerItem, res esutil.BulkIndexerResponseItem, err error) {
+			metrics.DocsIndexedTotal.WithLabelValues(item.Index, "failure").Inc()
 			fields := []zap.Field{
 				zap.String("index", item.Index),
 				zap.Int("status", res.Status),
@@ -183,9 +349,19 @@ func (s *Sender) indexAsync(ctx context.Context, index string, doc map[string]an
 			}
 		},
 	})
+	metrics.BulkFlushSeconds.Observe(time.Since(start).Seconds())
 	if err != nil {
+		if errors.Is(err, indexer.ErrQueueFull) {
+			metrics.IndexQueueDropsTotal.WithLabelValues(index).Inc()
+			return status.Error(codes.ResourceExhausted, "indexing queue is full")
+		}
 		s.logger.Error("Failed to add to indexer", zap.Error(err))
+		return nil
 	}
+
+	stats := s.bulkIndexer.Stats()
+	metrics.ObserveBulkIndexerStats(stats.NumAdded-stats.NumFlushed, stats.NumFlushed, stats.NumFailed)
+	return nil
 }
 
 // validateUUIDv7 checks if the given byte slice is a valid UUID v7.
@@ -193,30 +369,30 @@ func validateUUIDv7(data []byte, fieldName string) error {
 	if len(data) == 0 {
 		return fmt.Errorf("%s is required", fieldName)
 	}
-	
+
 	if len(data) != 16 {
 		return fmt.Errorf("%s must be 16 bytes, got %d", fieldName, len(data))
 	}
-	
+
 	// Parse as UUID
 	u, err := uuid.FromBytes(data)
 	if err != nil {
 		return fmt.Errorf("invalid %s: %w", fieldName, err)
 	}
-	
+
 	// Check if it's UUID v7 by examining the version bits
 	// UUID v7 has version 7 in the most significant 4 bits of the 7th byte (index 6)
 	version := (data[6] & 0xf0) >> 4
 	if version != 7 {
 		return fmt.Errorf("%s must be UUID v7, got version %d (UUID: %s)", fieldName, version, u.String())
 	}
-	
+
 	// Check variant bits (should be 10xx in the most significant bits of the 9th byte)
 	variant := (data[8] & 0xc0) >> 6
 	if variant != 2 { // Variant 10xx in binary = 2 in decimal
 		return fmt.Errorf("%s has invalid UUID variant", fieldName)
 	}
-	
+
 	return nil
 }
 
@@ -47,7 +47,10 @@ func TestSendTelemetryIndexesMetricsAndLogs(t *testing.T) {
 	cfg.Elastic.IndexLogs = "logs"
 	cfg.Elastic.IndexMetrics = "metrics"
 	cfg.Server.MaxContextAttrs = 6
-	sender := NewSender(zap.NewNop(), bi, cfg)
+	sender, err := NewSender(zap.NewNop(), bi, cfg)
+	if err != nil {
+		t.Fatalf("NewSender() error = %v", err)
+	}
 
 	packet := &pb.TelemetryPacket{
 		Metadata: &pb.ClientMetadata{
@@ -96,7 +99,10 @@ func TestSendTelemetry_InvalidInstallationID(t *testing.T) {
 	cfg := &config.Config{}
 	cfg.Elastic.IndexLogs = "logs"
 	cfg.Elastic.IndexMetrics = "metrics"
-	sender := NewSender(zap.NewNop(), bi, cfg)
+	sender, err := NewSender(zap.NewNop(), bi, cfg)
+	if err != nil {
+		t.Fatalf("NewSender() error = %v", err)
+	}
 
 	packet := &pb.TelemetryPacket{
 		Metadata: &pb.ClientMetadata{
@@ -107,7 +113,7 @@ func TestSendTelemetry_InvalidInstallationID(t *testing.T) {
 		},
 	}
 
-	_, err := sender.SendTelemetry(context.Background(), packet)
+	_, err = sender.SendTelemetry(context.Background(), packet)
 	if err == nil {
 		t.Fatal("expected error for invalid installation_id")
 	}
@@ -121,7 +127,10 @@ func TestSendTelemetry_InvalidJourneyID(t *testing.T) {
 	cfg := &config.Config{}
 	cfg.Elastic.IndexLogs = "logs"
 	cfg.Elastic.IndexMetrics = "metrics"
-	sender := NewSender(zap.NewNop(), bi, cfg)
+	sender, err := NewSender(zap.NewNop(), bi, cfg)
+	if err != nil {
+		t.Fatalf("NewSender() error = %v", err)
+	}
 
 	packet := &pb.TelemetryPacket{
 		Metadata: &pb.ClientMetadata{
@@ -132,7 +141,7 @@ func TestSendTelemetry_InvalidJourneyID(t *testing.T) {
 		},
 	}
 
-	_, err := sender.SendTelemetry(context.Background(), packet)
+	_, err = sender.SendTelemetry(context.Background(), packet)
 	if err == nil {
 		t.Fatal("expected error for invalid journey_id")
 	}
@@ -146,7 +155,10 @@ func TestSendTelemetry_PacketTooLarge(t *testing.T) {
 	cfg := &config.Config{}
 	cfg.Elastic.IndexLogs = "logs"
 	cfg.Elastic.IndexMetrics = "metrics"
-	sender := NewSender(zap.NewNop(), bi, cfg)
+	sender, err := NewSender(zap.NewNop(), bi, cfg)
+	if err != nil {
+		t.Fatalf("NewSender() error = %v", err)
+	}
 
 	// Create a packet with a very large message
 	largeMessage := strings.Repeat("a", 2000)
@@ -164,7 +176,7 @@ func TestSendTelemetry_PacketTooLarge(t *testing.T) {
 		},
 	}
 
-	_, err := sender.SendTelemetry(context.Background(), packet)
+	_, err = sender.SendTelemetry(context.Background(), packet)
 	if err == nil {
 		t.Fatal("expected error for packet too large")
 	}
@@ -179,7 +191,10 @@ func TestSendTelemetry_ContextMapLimit(t *testing.T) {
 	cfg.Elastic.IndexLogs = "logs"
 	cfg.Elastic.IndexMetrics = "metrics"
 	cfg.Server.MaxContextAttrs = 3 // Limit to 3
-	sender := NewSender(zap.NewNop(), bi, cfg)
+	sender, err := NewSender(zap.NewNop(), bi, cfg)
+	if err != nil {
+		t.Fatalf("NewSender() error = %v", err)
+	}
 
 	packet := &pb.TelemetryPacket{
 		Metadata: &pb.ClientMetadata{
@@ -208,7 +223,7 @@ func TestSendTelemetry_ContextMapLimit(t *testing.T) {
 	}
 
 	// Should now reject the packet with too many context attributes
-	_, err := sender.SendTelemetry(context.Background(), packet)
+	_, err = sender.SendTelemetry(context.Background(), packet)
 	if err == nil {
 		t.Fatal("expected error for too many context attributes")
 	}
@@ -223,7 +238,10 @@ func TestSendTelemetry_ContextMapWithinLimit(t *testing.T) {
 	cfg.Elastic.IndexLogs = "logs"
 	cfg.Elastic.IndexMetrics = "metrics"
 	cfg.Server.MaxContextAttrs = 3 // Limit to 3
-	sender := NewSender(zap.NewNop(), bi, cfg)
+	sender, err := NewSender(zap.NewNop(), bi, cfg)
+	if err != nil {
+		t.Fatalf("NewSender() error = %v", err)
+	}
 
 	packet := &pb.TelemetryPacket{
 		Metadata: &pb.ClientMetadata{
@@ -299,7 +317,7 @@ func TestValidateUUIDv7_WrongVersion(t *testing.T) {
 	// Create a UUID v4 (wrong version)
 	u := uuid.New()
 	b, _ := u.MarshalBinary()
-	
+
 	err := validateUUIDv7(b, "test_id")
 	if err == nil {
 		t.Fatal("expected error for wrong UUID version")
@@ -315,7 +333,7 @@ func TestValidatePacketSize_Valid(t *testing.T) {
 			Platform: pb.Platform_ANDROID,
 		},
 	}
-	
+
 	if err := validatePacketSize(packet, 1500); err != nil {
 		t.Fatalf("expected valid packet size, got error: %v", err)
 	}
@@ -333,7 +351,7 @@ func TestValidatePacketSize_TooLarge(t *testing.T) {
 			},
 		},
 	}
-	
+
 	err := validatePacketSize(packet, 1500)
 	if err == nil {
 		t.Fatal("expected error for packet too large")
@@ -0,0 +1,94 @@
+package ingest
+
+import "sort"
+
+// centroid is a single cluster in a t-digest: a mean and the count of
+// samples merged into it.
+type centroid struct {
+	Mean  float64
+	Count float64
+}
+
+// tDigest is a small in-repo approximate quantile sketch, merging centroids
+// with the k1 scale function from Dunning & Ertl, "Computing Extremely
+// Accurate Quantiles Using t-Digests". It favors simplicity over the
+// paper's buffered-merge strategy: compress() re-sorts and re-merges the
+// full centroid list, which is fine at the per-bucket sample volumes this
+// package deals with.
+type tDigest struct {
+	compression float64
+	centroids   []centroid
+	count       float64
+}
+
+// newTDigest returns a t-digest with the given compression factor (higher
+// means more centroids and better accuracy). 100 is a reasonable default.
+func newTDigest(compression float64) *tDigest {
+	if compression <= 0 {
+		compression = 100
+	}
+	return &tDigest{compression: compression}
+}
+
+// Add records a single sample.
+func (d *tDigest) Add(x float64) {
+	d.centroids = append(d.centroids, centroid{Mean: x, Count: 1})
+	d.count++
+	if float64(len(d.centroids)) > d.compression*20 {
+		d.compress()
+	}
+}
+
+// compress merges adjacent centroids until each respects the k1 scale
+// function's weight limit for its approximate quantile.
+func (d *tDigest) compress() {
+	if len(d.centroids) == 0 {
+		return
+	}
+	sort.Slice(d.centroids, func(i, j int) bool { return d.centroids[i].Mean < d.centroids[j].Mean })
+
+	merged := make([]centroid, 0, len(d.centroids))
+	cur := d.centroids[0]
+	soFar := float64(0)
+
+	for _, c := range d.centroids[1:] {
+		q := (soFar + cur.Count + c.Count/2) / d.count
+		if cur.Count+c.Count <= d.scaleLimit(q) {
+			total := cur.Count + c.Count
+			cur.Mean = (cur.Mean*cur.Count + c.Mean*c.Count) / total
+			cur.Count = total
+			continue
+		}
+		merged = append(merged, cur)
+		soFar += cur.Count
+		cur = c
+	}
+	merged = append(merged, cur)
+	d.centroids = merged
+}
+
+// scaleLimit is the k1 scale function: it bounds centroid weight tightly
+// near q=0 and q=1 (where quantile estimates matter most) and loosely near
+// the median.
+func (d *tDigest) scaleLimit(q float64) float64 {
+	return 4 * d.count * q * (1 - q) / d.compression
+}
+
+// Quantile returns the approximate value at quantile q (0..1), or 0 if no
+// samples have been added.
+func (d *tDigest) Quantile(q float64) float64 {
+	if d.count == 0 {
+		return 0
+	}
+	d.compress()
+
+	target := q * d.count
+	var soFar float64
+	for _, c := range d.centroids {
+		soFar += c.Count
+		if soFar >= target {
+			return c.Mean
+		}
+	}
+	return d.centroids[len(d.centroids)-1].Mean
+}
@@ -0,0 +1,180 @@
+// Package metrics defines the Prometheus collectors shared across the
+// ingest pipeline, the HTTP/gRPC servers, and the rate limiter.
+package metrics
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	// PacketsTotal counts telemetry packets received, by outcome
+	// ("accepted" or "rejected").
+	PacketsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tf_telemetry_packets_total",
+		Help: "Telemetry packets received, by outcome.",
+	}, []string{"result"})
+
+	// DocsIndexedTotal counts documents handed to the bulk indexer, by
+	// target index and outcome ("success" or "failure").
+	DocsIndexedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tf_telemetry_docs_indexed_total",
+		Help: "Documents handed to the bulk indexer, by index and outcome.",
+	}, []string{"index", "result"})
+
+	// ValidationErrorsTotal counts packets rejected at validation, by the
+	// field that failed validation.
+	ValidationErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tf_telemetry_validation_errors_total",
+		Help: "Telemetry packets rejected at validation, by field.",
+	}, []string{"field"})
+
+	// PacketSizeBytes observes the wire size of accepted telemetry packets.
+	PacketSizeBytes = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "tf_telemetry_packet_size_bytes",
+		Help:    "Size of accepted telemetry packets in bytes.",
+		Buckets: prometheus.ExponentialBuckets(64, 2, 12),
+	})
+
+	// BulkFlushSeconds observes how long handing a document to the bulk
+	// indexer takes (enqueue time, not the indexer's own flush interval).
+	BulkFlushSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "tf_telemetry_bulk_flush_seconds",
+		Help:    "Time spent handing a document to the bulk indexer.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	// RateLimiterRejectsTotal counts requests rejected by the per-key rate
+	// limiter. Deliberately unlabeled by key: the key is an installation
+	// ID/customer ID/bearer subject - exactly the unbounded, attacker-
+	// controllable input this middleware defends against - so labeling by it
+	// would let a single abusive client explode this metric's cardinality.
+	RateLimiterRejectsTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "tf_telemetry_rate_limiter_rejects_total",
+		Help: "Requests rejected by the per-key rate limiter.",
+	})
+	// RateLimiterAllowedTotal counts requests allowed by the per-key rate
+	// limiter. Unlabeled for the same cardinality reason as
+	// RateLimiterRejectsTotal.
+	RateLimiterAllowedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "tf_telemetry_rate_limiter_allowed_total",
+		Help: "Requests allowed by the per-key rate limiter.",
+	})
+	// RateLimiterEvictedTotal counts keys evicted by the rate limiter's idle janitor.
+	RateLimiterEvictedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "tf_telemetry_rate_limiter_evicted_total",
+		Help: "Keys evicted from the rate limiter after going idle.",
+	})
+	// RateLimiterActiveKeys gauges the number of keys currently tracked by the rate limiter.
+	RateLimiterActiveKeys = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "tf_telemetry_rate_limiter_active_keys",
+		Help: "Keys currently tracked by the rate limiter.",
+	})
+
+	// BulkIndexerQueued, BulkIndexerFlushed, and BulkIndexerFailed mirror
+	// esutil.BulkIndexerStats so operators can watch ES back-pressure.
+	BulkIndexerQueued = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "tf_telemetry_bulk_indexer_queued",
+		Help: "Items currently queued in the bulk indexer.",
+	})
+	BulkIndexerFlushed = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "tf_telemetry_bulk_indexer_flushed_total",
+		Help: "Items successfully flushed by the bulk indexer.",
+	})
+	BulkIndexerFailed = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "tf_telemetry_bulk_indexer_failed_total",
+		Help: "Items that failed to flush from the bulk indexer.",
+	})
+
+	// IndexQueueDepth is registered for documents in flight between
+	// SendTelemetry and the bulk indexer; no code in this module sets it, so
+	// it stays at 0.
+	IndexQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "tf_telemetry_index_queue_depth",
+		Help: "Documents currently in flight between SendTelemetry and the bulk indexer.",
+	})
+	// IndexQueueDropsTotal counts documents dropped or rejected due to
+	// indexing backpressure, by target index.
+	IndexQueueDropsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tf_telemetry_index_queue_drops_total",
+		Help: "Documents dropped or rejected due to indexing backpressure, by index.",
+	}, []string{"index"})
+	// IndexEnqueueSeconds is registered for how long SendTelemetry waits for
+	// an inflight slot before handing a document to the bulk indexer; no code
+	// in this module observes it, so it stays empty.
+	IndexEnqueueSeconds = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "tf_telemetry_index_enqueue_seconds",
+		Help:    "Time spent waiting for a free inflight slot before indexing a document.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	// RequestsTotal counts SendTelemetry calls recorded by MetricsUnary, by
+	// client platform, SDK version, and the gRPC status code returned.
+	RequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "tf_telemetry_requests_total",
+		Help: "SendTelemetry requests, by platform, sdk_version, and gRPC status code.",
+	}, []string{"platform", "sdk_version", "code"})
+	// RequestDurationSeconds observes how long a SendTelemetry call took
+	// end-to-end, by platform, SDK version, and gRPC status code.
+	RequestDurationSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tf_telemetry_request_duration_seconds",
+		Help:    "SendTelemetry request latency, by platform, sdk_version, and gRPC status code.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"platform", "sdk_version", "code"})
+	// RequestPayloadSizeBytes observes the wire size of a SendTelemetry
+	// request's serialized packet, by platform and SDK version.
+	RequestPayloadSizeBytes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "tf_telemetry_request_payload_size_bytes",
+		Help:    "Size of a SendTelemetry request's serialized packet, by platform and sdk_version.",
+		Buckets: prometheus.ExponentialBuckets(64, 2, 12),
+	}, []string{"platform", "sdk_version"})
+
+	// QueueDepthBytes and QueueOldestAgeSeconds expose the on-disk durable
+	// queue's backlog, distinct from BulkIndexerQueued/Flushed/Failed which
+	// track the in-memory esutil.BulkIndexer the queue drains into.
+	QueueDepthBytes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "tf_telemetry_queue_depth_bytes",
+		Help: "Bytes of queued records not yet delivered to Elasticsearch by the durable queue.",
+	})
+	QueueOldestAgeSeconds = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "tf_telemetry_queue_oldest_age_seconds",
+		Help: "Age of the oldest undelivered record in the durable queue, in seconds.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		PacketsTotal,
+		DocsIndexedTotal,
+		ValidationErrorsTotal,
+		PacketSizeBytes,
+		BulkFlushSeconds,
+		RateLimiterRejectsTotal,
+		RateLimiterAllowedTotal,
+		RateLimiterEvictedTotal,
+		RateLimiterActiveKeys,
+		BulkIndexerQueued,
+		BulkIndexerFlushed,
+		BulkIndexerFailed,
+		IndexQueueDepth,
+		IndexQueueDropsTotal,
+		IndexEnqueueSeconds,
+		RequestsTotal,
+		RequestDurationSeconds,
+		RequestPayloadSizeBytes,
+		QueueDepthBytes,
+		QueueOldestAgeSeconds,
+	)
+}
+
+// ObserveBulkIndexerStats updates the bulk indexer gauges from a snapshot of
+// esutil.BulkIndexerStats. Taking the raw counters (rather than the esutil
+// type) keeps this package free of an esutil dependency.
+func ObserveBulkIndexerStats(queued, flushed, failed uint64) {
+	BulkIndexerQueued.Set(float64(queued))
+	BulkIndexerFlushed.Set(float64(flushed))
+	BulkIndexerFailed.Set(float64(failed))
+}
+
+// ObserveQueueStats updates the durable queue's backlog gauges.
+func ObserveQueueStats(depthBytes int64, oldestAgeSeconds float64) {
+	QueueDepthBytes.Set(float64(depthBytes))
+	QueueOldestAgeSeconds.Set(oldestAgeSeconds)
+}
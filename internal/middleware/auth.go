@@ -13,9 +13,86 @@ import (
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 
-	"github.com/minhtt159/tf-telemetry/internal/config"
+	"github.com/threatfabric-devops/tf-telemetry/internal/config"
 )
 
+type principalKey struct{}
+
+// ContextWithPrincipal attaches the authenticated principal (basic auth
+// username, certificate CN/SAN, or JWT subject) to ctx so downstream stages
+// can stamp it on indexed documents for audit.
+func ContextWithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFromContext returns the principal attached by an auth
+// middleware/interceptor, if any.
+func PrincipalFromContext(ctx context.Context) (string, bool) {
+	principal, ok := ctx.Value(principalKey{}).(string)
+	return principal, ok
+}
+
+// AuthAttempt inspects an HTTP request and returns the resolved principal
+// when it authenticates the request successfully.
+type AuthAttempt func(r *http.Request) (principal string, ok bool)
+
+// ComposeAuthHTTP wraps next so a request is let through if any one of
+// attempts authenticates it (any-of composition across Basic Auth, mTLS,
+// and JWT). The resolved principal is attached to the request context.
+func ComposeAuthHTTP(next http.Handler, attempts ...AuthAttempt) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, attempt := range attempts {
+			if principal, ok := attempt(r); ok {
+				next.ServeHTTP(w, r.WithContext(ContextWithPrincipal(r.Context(), principal)))
+				return
+			}
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="Restricted"`)
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+}
+
+// BasicAuthAttempt adapts BasicAuthHTTP's credential check into an AuthAttempt.
+func BasicAuthAttempt(cfg config.BasicAuthConfig) AuthAttempt {
+	return func(r *http.Request) (string, bool) {
+		username, password, ok := r.BasicAuth()
+		if !ok || !credentialsMatch(username, password, cfg) {
+			return "", false
+		}
+		return username, true
+	}
+}
+
+// GRPCAuthAttempt is the gRPC analogue of AuthAttempt.
+type GRPCAuthAttempt func(ctx context.Context) (principal string, ok bool)
+
+// ComposeAuthUnary is the gRPC analogue of ComposeAuthHTTP.
+func ComposeAuthUnary(attempts ...GRPCAuthAttempt) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		for _, attempt := range attempts {
+			if principal, ok := attempt(ctx); ok {
+				return handler(ContextWithPrincipal(ctx, principal), req)
+			}
+		}
+		return nil, status.Error(codes.Unauthenticated, "no configured auth method accepted the request")
+	}
+}
+
+// BasicAuthAttemptGRPC adapts basicAuthCredentialsFromMD into a GRPCAuthAttempt.
+func BasicAuthAttemptGRPC(cfg config.BasicAuthConfig) GRPCAuthAttempt {
+	return func(ctx context.Context) (string, bool) {
+		md, ok := metadata.FromIncomingContext(ctx)
+		if !ok {
+			return "", false
+		}
+		username, password, err := basicAuthCredentialsFromMD(md)
+		if err != nil || !credentialsMatch(username, password, cfg) {
+			return "", false
+		}
+		return username, true
+	}
+}
+
 // BasicAuthHTTP wraps an HTTP handler with basic auth validation.
 func BasicAuthHTTP(cfg config.BasicAuthConfig) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -46,28 +123,38 @@ func BasicAuthUnary(cfg config.BasicAuthConfig) grpc.UnaryServerInterceptor {
 }
 
 func validateBasicAuth(md metadata.MD, cfg config.BasicAuthConfig) error {
+	username, password, err := basicAuthCredentialsFromMD(md)
+	if err != nil {
+		return err
+	}
+	if !credentialsMatch(username, password, cfg) {
+		return errors.New("invalid credentials")
+	}
+	return nil
+}
+
+// basicAuthCredentialsFromMD extracts the username/password pair from a
+// gRPC request's "authorization: Basic ..." metadata.
+func basicAuthCredentialsFromMD(md metadata.MD) (username, password string, err error) {
 	authHeaders := md.Get("authorization")
 	if len(authHeaders) == 0 {
-		return errors.New("authorization header missing")
+		return "", "", errors.New("authorization header missing")
 	}
 	const prefix = "Basic "
 	header := authHeaders[0]
 	if !strings.HasPrefix(header, prefix) {
-		return errors.New("invalid authorization header")
+		return "", "", errors.New("invalid authorization header")
 	}
 
 	decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(header, prefix))
 	if err != nil {
-		return errors.New("invalid base64 in authorization header")
+		return "", "", errors.New("invalid base64 in authorization header")
 	}
 	parts := strings.SplitN(string(decoded), ":", 2)
 	if len(parts) != 2 {
-		return errors.New("invalid authorization value")
-	}
-	if !credentialsMatch(parts[0], parts[1], cfg) {
-		return errors.New("invalid credentials")
+		return "", "", errors.New("invalid authorization value")
 	}
-	return nil
+	return parts[0], parts[1], nil
 }
 
 func credentialsMatch(username, password string, cfg config.BasicAuthConfig) bool {
@@ -0,0 +1,122 @@
+package middleware
+
+import (
+	"net/http"
+
+	"google.golang.org/grpc"
+
+	"github.com/threatfabric-devops/tf-telemetry/internal/config"
+)
+
+// AuthChain resolves cfg.Server's mTLS/JWT(JWKS)/BasicAuth/JWTAuth settings
+// into a single named set of auth attempts, in place of the bespoke
+// attempts-slice each of httpserver.New and grpcserver.New used to build by
+// hand. DefaultOrder is the server-wide any-of chain (mtls first when
+// enabled, then jwks when cfg.Server.JWT is enabled, then the enabled
+// schemes of cfg.Server.Auth.OrderedSchemes()); WrapHTTP/UnaryInterceptor
+// accept an optional narrower subset for a per-route requirement, per
+// config.AuthConfig.PerRoute. gRPC has no per-method interception hook in
+// this codebase, so UnaryInterceptor's override argument only matters when
+// a caller builds a second, route-specific server - the server-wide gRPC
+// service always runs DefaultOrder.
+type AuthChain struct {
+	httpAttempts map[string]AuthAttempt
+	grpcAttempts map[string]GRPCAuthAttempt
+	defaultOrder []string
+}
+
+// NewAuthChain builds an AuthChain from cfg, constructing whichever
+// verifiers are enabled. A JWKSURL/JWKS endpoint is fetched synchronously,
+// so an unreachable one surfaces here rather than on first request.
+func NewAuthChain(cfg *config.Config) (*AuthChain, error) {
+	chain := &AuthChain{
+		httpAttempts: make(map[string]AuthAttempt),
+		grpcAttempts: make(map[string]GRPCAuthAttempt),
+	}
+
+	if cfg.Server.MTLS.Enabled {
+		chain.httpAttempts["mtls"] = MTLSAttempt(cfg.Server.MTLS)
+		chain.grpcAttempts["mtls"] = MTLSAttemptGRPC(cfg.Server.MTLS)
+		chain.defaultOrder = append(chain.defaultOrder, "mtls")
+	}
+
+	if cfg.Server.JWT.Enabled {
+		verifier, err := NewJWKSVerifier(cfg.Server.JWT)
+		if err != nil {
+			return nil, err
+		}
+		chain.httpAttempts["jwks"] = JWTAttempt(verifier)
+		chain.grpcAttempts["jwks"] = JWTAttemptGRPC(verifier)
+		chain.defaultOrder = append(chain.defaultOrder, "jwks")
+	}
+
+	if cfg.Server.BasicAuth.Enabled && cfg.Server.Auth.Includes("basic") {
+		chain.httpAttempts["basic"] = BasicAuthAttempt(cfg.Server.BasicAuth)
+		chain.grpcAttempts["basic"] = BasicAuthAttemptGRPC(cfg.Server.BasicAuth)
+	}
+	if cfg.Server.JWTAuth.Enabled && cfg.Server.Auth.Includes("jwt") {
+		verifier, err := NewJWTVerifier(cfg.Server.JWTAuth)
+		if err != nil {
+			return nil, err
+		}
+		chain.httpAttempts["jwt"] = JWTAuthAttempt(verifier)
+		chain.grpcAttempts["jwt"] = JWTAuthAttemptGRPC(verifier)
+	}
+	for _, scheme := range cfg.Server.Auth.OrderedSchemes() {
+		if _, ok := chain.httpAttempts[scheme]; ok {
+			chain.defaultOrder = append(chain.defaultOrder, scheme)
+		}
+	}
+
+	return chain, nil
+}
+
+// RequiredFor returns the ordered subset of "mtls", "jwks", "jwt", "basic"
+// that path requires, consulting cfg.Server.Auth.PerRoute when it names
+// path and falling back to the chain's server-wide default order otherwise.
+func (c *AuthChain) RequiredFor(cfg *config.Config, path string) []string {
+	if names, ok := cfg.Server.Auth.PerRoute[path]; ok {
+		return names
+	}
+	return c.defaultOrder
+}
+
+// WrapHTTP wraps next behind the any-of chain named by names, or the
+// server-wide default order when names is empty. It returns next unwrapped
+// if the resolved set of attempts is empty, matching the behavior of no
+// auth being configured.
+func (c *AuthChain) WrapHTTP(next http.Handler, names ...string) http.Handler {
+	if len(names) == 0 {
+		names = c.defaultOrder
+	}
+	attempts := make([]AuthAttempt, 0, len(names))
+	for _, name := range names {
+		if attempt, ok := c.httpAttempts[name]; ok {
+			attempts = append(attempts, attempt)
+		}
+	}
+	if len(attempts) == 0 {
+		return next
+	}
+	return ComposeAuthHTTP(next, attempts...)
+}
+
+// UnaryInterceptor builds the gRPC any-of interceptor for names, or the
+// server-wide default order when names is empty. It returns nil if the
+// resolved set of attempts is empty, matching grpcserver.New's existing
+// "only chain in an auth interceptor when one is configured" behavior.
+func (c *AuthChain) UnaryInterceptor(names ...string) grpc.UnaryServerInterceptor {
+	if len(names) == 0 {
+		names = c.defaultOrder
+	}
+	attempts := make([]GRPCAuthAttempt, 0, len(names))
+	for _, name := range names {
+		if attempt, ok := c.grpcAttempts[name]; ok {
+			attempts = append(attempts, attempt)
+		}
+	}
+	if len(attempts) == 0 {
+		return nil
+	}
+	return ComposeAuthUnary(attempts...)
+}
@@ -0,0 +1,19 @@
+package middleware
+
+import "net/http"
+
+// MaxBodyBytesHTTP caps the request body read by downstream handlers at
+// maxBytes via http.MaxBytesReader, so a client can't make the server buffer
+// an arbitrarily large payload before size validation runs. A maxBytes of
+// zero disables the cap.
+func MaxBodyBytesHTTP(maxBytes int64) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if maxBytes <= 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/threatfabric-devops/tf-telemetry/internal/config"
+)
+
+// defaultMaxDecompressedBytes bounds request-body decompression when
+// config.CompressionConfig.MaxDecompressedBytes is unset, protecting the
+// protobuf decoder from a zip-bomb-style Content-Encoding body.
+const defaultMaxDecompressedBytes = 16 << 20 // 16 MiB
+
+var errDecompressedTooLarge = fmt.Errorf("decompressed body exceeds configured limit")
+
+// CompressionHTTP transparently decompresses a gzip/zstd request body ahead
+// of the protobuf decoder and, when the client's Accept-Encoding allows it,
+// compresses responses at or above cfg.MinSize. It should wrap the handler
+// chain ahead of BasicAuthHTTP/RateLimitHTTP so both see decompressed
+// bodies and so compressed error responses still carry the right headers.
+func CompressionHTTP(cfg config.CompressionConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !cfg.Enabled {
+			return next
+		}
+		enabled := toLowerSet(cfg.EnabledEncodings)
+		if len(enabled) == 0 {
+			enabled = map[string]bool{"gzip": true, "zstd": true}
+		}
+		maxDecompressed := cfg.MaxDecompressedBytes
+		if maxDecompressed <= 0 {
+			maxDecompressed = defaultMaxDecompressedBytes
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ce := r.Header.Get("Content-Encoding"); ce != "" {
+				decoded, err := decompressRequestBody(r.Body, ce, enabled, maxDecompressed)
+				if err != nil {
+					if err == errDecompressedTooLarge {
+						http.Error(w, "request body too large after decompression", http.StatusRequestEntityTooLarge)
+					} else {
+						http.Error(w, "invalid request encoding", http.StatusBadRequest)
+					}
+					return
+				}
+				r.Body = decoded
+				r.Header.Del("Content-Encoding")
+				r.ContentLength = -1
+			}
+
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"), enabled)
+			if encoding == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			buf := &bufferedResponseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+			next.ServeHTTP(buf, r)
+			flushCompressed(w, buf, encoding, cfg.Level, cfg.MinSize)
+		})
+	}
+}
+
+// bufferedResponseWriter buffers a handler's response so CompressionHTTP
+// can decide, once the full body is known, whether it clears MinSize and
+// is worth compressing.
+type bufferedResponseWriter struct {
+	http.ResponseWriter
+	buf        bytes.Buffer
+	statusCode int
+}
+
+func (w *bufferedResponseWriter) WriteHeader(code int) {
+	w.statusCode = code
+}
+
+func (w *bufferedResponseWriter) Write(p []byte) (int, error) {
+	return w.buf.Write(p)
+}
+
+func flushCompressed(w http.ResponseWriter, buf *bufferedResponseWriter, encoding string, level, minSize int) {
+	if buf.buf.Len() < minSize {
+		w.WriteHeader(buf.statusCode)
+		_, _ = w.Write(buf.buf.Bytes())
+		return
+	}
+
+	compressed, err := compressBytes(buf.buf.Bytes(), encoding, level)
+	if err != nil {
+		w.WriteHeader(buf.statusCode)
+		_, _ = w.Write(buf.buf.Bytes())
+		return
+	}
+
+	w.Header().Set("Content-Encoding", encoding)
+	w.Header().Set("Content-Length", strconv.Itoa(len(compressed)))
+	w.WriteHeader(buf.statusCode)
+	_, _ = w.Write(compressed)
+}
+
+// negotiateEncoding picks the first mutually-supported encoding named in
+// acceptEncoding, preferring zstd over gzip when both are offered and
+// enabled.
+func negotiateEncoding(acceptEncoding string, enabled map[string]bool) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+	offered := make(map[string]bool)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name := strings.TrimSpace(strings.SplitN(part, ";", 2)[0])
+		offered[strings.ToLower(name)] = true
+	}
+	for _, candidate := range []string{"zstd", "gzip"} {
+		if offered[candidate] && enabled[candidate] {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// zstdEncoderLevel maps a CompressionConfig.Level (the same small integer
+// scale gzip uses) onto klauspost/compress's coarser four-level scale.
+func zstdEncoderLevel(level int) zstd.EncoderLevel {
+	switch {
+	case level <= 0:
+		return zstd.SpeedDefault
+	case level <= 3:
+		return zstd.SpeedFastest
+	case level <= 6:
+		return zstd.SpeedDefault
+	case level <= 9:
+		return zstd.SpeedBetterCompression
+	default:
+		return zstd.SpeedBestCompression
+	}
+}
+
+func compressBytes(data []byte, encoding string, level int) ([]byte, error) {
+	var buf bytes.Buffer
+	switch encoding {
+	case "gzip":
+		gzLevel := level
+		if gzLevel == 0 {
+			gzLevel = gzip.DefaultCompression
+		}
+		gw, err := gzip.NewWriterLevel(&buf, gzLevel)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := gw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := gw.Close(); err != nil {
+			return nil, err
+		}
+	case "zstd":
+		zw, err := zstd.NewWriter(&buf, zstd.WithEncoderLevel(zstdEncoderLevel(level)))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := zw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q", encoding)
+	}
+	return buf.Bytes(), nil
+}
+
+// decompressRequestBody decompresses body according to contentEncoding,
+// capping the output at maxDecompressedBytes to guard against a zip bomb:
+// a small compressed payload that expands far beyond what the caller
+// intends to process.
+func decompressRequestBody(body io.ReadCloser, contentEncoding string, enabled map[string]bool, maxDecompressedBytes int64) (io.ReadCloser, error) {
+	defer body.Close()
+
+	encoding := strings.ToLower(strings.TrimSpace(contentEncoding))
+	if !enabled[encoding] {
+		return nil, fmt.Errorf("unsupported content encoding %q", contentEncoding)
+	}
+
+	var reader io.Reader
+	switch encoding {
+	case "gzip":
+		gr, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		reader = gr
+	case "zstd":
+		zr, err := zstd.NewReader(body)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		reader = zr
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", contentEncoding)
+	}
+
+	limited := io.LimitReader(reader, maxDecompressedBytes+1)
+	data, err := io.ReadAll(limited)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxDecompressedBytes {
+		return nil, errDecompressedTooLarge
+	}
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
@@ -0,0 +1,199 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/threatfabric-devops/tf-telemetry/internal/config"
+)
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	if _, err := gw.Write(data); err != nil {
+		t.Fatalf("gzip write: %v", err)
+	}
+	if err := gw.Close(); err != nil {
+		t.Fatalf("gzip close: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestCompressionHTTP_ResponseRoundTrip(t *testing.T) {
+	cfg := config.CompressionConfig{Enabled: true, MinSize: 10}
+	body := strings.Repeat("telemetry-payload-", 20)
+
+	handler := CompressionHTTP(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if got := w.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("expected gzip encoding, got %q", got)
+	}
+	if got := w.Header().Get("Vary"); got != "Accept-Encoding" {
+		t.Fatalf("expected Vary: Accept-Encoding, got %q", got)
+	}
+
+	gr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("gzip.NewReader: %v", err)
+	}
+	decoded, err := io.ReadAll(gr)
+	if err != nil {
+		t.Fatalf("read decoded body: %v", err)
+	}
+	if string(decoded) != body {
+		t.Fatalf("expected round-tripped body %q, got %q", body, decoded)
+	}
+}
+
+func TestCompressionHTTP_BelowMinSizeNotCompressed(t *testing.T) {
+	cfg := config.CompressionConfig{Enabled: true, MinSize: 1024}
+
+	handler := CompressionHTTP(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("short"))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if got := w.Header().Get("Content-Encoding"); got != "" {
+		t.Fatalf("expected no Content-Encoding for a small response, got %q", got)
+	}
+	if w.Body.String() != "short" {
+		t.Fatalf("expected uncompressed body, got %q", w.Body.String())
+	}
+}
+
+func TestCompressionHTTP_NoAcceptEncoding(t *testing.T) {
+	cfg := config.CompressionConfig{Enabled: true, MinSize: 1}
+
+	handler := CompressionHTTP(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello world"))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if got := w.Header().Get("Content-Encoding"); got != "" {
+		t.Fatalf("expected no Content-Encoding without Accept-Encoding, got %q", got)
+	}
+	if w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Fatal("expected Vary: Accept-Encoding to still be set")
+	}
+}
+
+func TestCompressionHTTP_DecompressesGzipRequestBody(t *testing.T) {
+	cfg := config.CompressionConfig{Enabled: true}
+	original := []byte(`{"hello":"world"}`)
+
+	var gotBody []byte
+	handler := CompressionHTTP(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		gotBody, err = io.ReadAll(r.Body)
+		if err != nil {
+			t.Fatalf("read body: %v", err)
+		}
+		if r.Header.Get("Content-Encoding") != "" {
+			t.Fatal("expected Content-Encoding to be stripped before reaching the handler")
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/test", bytes.NewReader(gzipBytes(t, original)))
+	req.Header.Set("Content-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+	if string(gotBody) != string(original) {
+		t.Fatalf("expected decompressed body %q, got %q", original, gotBody)
+	}
+}
+
+func TestCompressionHTTP_DecompressedSizeCapEnforced(t *testing.T) {
+	cfg := config.CompressionConfig{Enabled: true, MaxDecompressedBytes: 8}
+
+	handler := CompressionHTTP(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("handler should not be called when the decompressed body exceeds the cap")
+	}))
+
+	large := bytes.Repeat([]byte("x"), 4096)
+	req := httptest.NewRequest(http.MethodPost, "/test", bytes.NewReader(gzipBytes(t, large)))
+	req.Header.Set("Content-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413 for an over-cap decompressed body, got %d", w.Code)
+	}
+}
+
+func TestCompressionHTTP_ZstdRoundTrip(t *testing.T) {
+	cfg := config.CompressionConfig{Enabled: true, MinSize: 1}
+	body := strings.Repeat("zstd-payload-", 50)
+
+	handler := CompressionHTTP(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("Accept-Encoding", "zstd")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if got := w.Header().Get("Content-Encoding"); got != "zstd" {
+		t.Fatalf("expected zstd encoding, got %q", got)
+	}
+
+	zr, err := zstd.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("zstd.NewReader: %v", err)
+	}
+	defer zr.Close()
+	decoded, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("read decoded body: %v", err)
+	}
+	if string(decoded) != body {
+		t.Fatalf("expected round-tripped body %q, got %q", body, decoded)
+	}
+}
+
+func TestCompressionHTTP_Disabled(t *testing.T) {
+	cfg := config.CompressionConfig{Enabled: false}
+
+	handler := CompressionHTTP(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("plain"))
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if got := w.Header().Get("Content-Encoding"); got != "" {
+		t.Fatalf("expected no compression when disabled, got %q", got)
+	}
+	if w.Body.String() != "plain" {
+		t.Fatalf("expected plain body passthrough, got %q", w.Body.String())
+	}
+}
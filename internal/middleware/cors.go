@@ -2,71 +2,281 @@ package middleware
 
 import (
 	"net/http"
+	"regexp"
+	"strconv"
 	"strings"
 
 	"github.com/threatfabric-devops/tf-telemetry/internal/config"
 )
 
-func CorsMiddleware(next http.Handler, cfg config.CORSConfig) http.Handler {
-	if !cfg.Enabled {
-		return next
+var defaultAllowedMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
+var defaultAllowedHeaders = []string{"Content-Type", "Authorization", "X-Requested-With"}
+
+// originPattern matches a single config.CORSConfig.AllowedOrigins entry: an
+// exact origin, the literal wildcard "*", or a glob such as
+// "https://*.threatfabric.com" compiled to a regexp once up front.
+type originPattern struct {
+	raw      string
+	wildcard bool
+	regex    *regexp.Regexp
+}
+
+func compileOriginPattern(raw string) (originPattern, error) {
+	if raw == "*" {
+		return originPattern{raw: raw, wildcard: true}, nil
+	}
+	if !strings.Contains(raw, "*") {
+		return originPattern{raw: raw}, nil
+	}
+	escaped := strings.ReplaceAll(regexp.QuoteMeta(raw), `\*`, `.*`)
+	re, err := regexp.Compile("^" + escaped + "$")
+	if err != nil {
+		return originPattern{}, err
+	}
+	return originPattern{raw: raw, regex: re}, nil
+}
+
+func (p originPattern) matches(origin string) bool {
+	switch {
+	case p.wildcard:
+		return true
+	case p.regex != nil:
+		return p.regex.MatchString(origin)
+	default:
+		return p.raw == origin
+	}
+}
+
+// CORSPolicy is a compiled, ready-to-serve form of a config.CORSConfig:
+// origin patterns are parsed and header values pre-joined once at
+// construction rather than on every request.
+type CORSPolicy struct {
+	cfg            config.CORSConfig
+	patterns       []originPattern
+	methodsHeader  string
+	allowedMethods map[string]bool
+	headersHeader  string
+	allowedHeaders map[string]bool
+	exposedHeaders string
+	maxAgeHeader   string
+}
+
+// NewCORSPolicy compiles cfg into a CORSPolicy, returning an error if an
+// AllowedOrigins entry is not a valid glob pattern.
+func NewCORSPolicy(cfg config.CORSConfig) (*CORSPolicy, error) {
+	p := &CORSPolicy{cfg: cfg}
+
+	for _, raw := range cfg.AllowedOrigins {
+		pat, err := compileOriginPattern(raw)
+		if err != nil {
+			return nil, err
+		}
+		p.patterns = append(p.patterns, pat)
 	}
 
-	// Set sensible defaults if not configured
-	allowedMethods := cfg.AllowedMethods
-	if len(allowedMethods) == 0 {
-		allowedMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
+	methods := cfg.AllowedMethods
+	if len(methods) == 0 {
+		methods = defaultAllowedMethods
 	}
+	p.methodsHeader = strings.Join(methods, ", ")
+	p.allowedMethods = toUpperSet(methods)
 
-	allowedHeaders := cfg.AllowedHeaders
-	if len(allowedHeaders) == 0 {
-		allowedHeaders = []string{"Content-Type", "Authorization", "X-Requested-With"}
+	// Unlike methods, headers are only advertised in the response when the
+	// operator explicitly configures AllowedHeaders; an unset list still
+	// permits the default set for preflight validation purposes.
+	validationHeaders := cfg.AllowedHeaders
+	if len(validationHeaders) == 0 {
+		validationHeaders = defaultAllowedHeaders
+	} else {
+		p.headersHeader = strings.Join(cfg.AllowedHeaders, ", ")
 	}
+	p.allowedHeaders = toLowerSet(validationHeaders)
 
-	// Join methods and headers for header values
-	methodsStr := strings.Join(allowedMethods, ", ")
-	headersStr := strings.Join(allowedHeaders, ", ")
+	if len(cfg.ExposedHeaders) > 0 {
+		p.exposedHeaders = strings.Join(cfg.ExposedHeaders, ", ")
+	}
+	if cfg.MaxAge > 0 {
+		p.maxAgeHeader = strconv.Itoa(int(cfg.MaxAge.Seconds()))
+	}
 
+	return p, nil
+}
+
+// WithOverride returns a middleware factory for a route that wants to
+// relax or tighten only some of the base policy's settings: any non-zero
+// field on override replaces the corresponding field inherited from the
+// policy this is called on, and the rest are reused as-is.
+func (p *CORSPolicy) WithOverride(override config.CORSConfig) (*CORSPolicy, error) {
+	return NewCORSPolicy(mergeCORSConfig(p.cfg, override))
+}
+
+func mergeCORSConfig(base, override config.CORSConfig) config.CORSConfig {
+	merged := base
+	merged.Enabled = override.Enabled || base.Enabled
+	if len(override.AllowedOrigins) > 0 {
+		merged.AllowedOrigins = override.AllowedOrigins
+	}
+	if len(override.AllowedMethods) > 0 {
+		merged.AllowedMethods = override.AllowedMethods
+	}
+	if len(override.AllowedHeaders) > 0 {
+		merged.AllowedHeaders = override.AllowedHeaders
+	}
+	if len(override.ExposedHeaders) > 0 {
+		merged.ExposedHeaders = override.ExposedHeaders
+	}
+	if override.AllowCredentials {
+		merged.AllowCredentials = true
+	}
+	if override.MaxAge > 0 {
+		merged.MaxAge = override.MaxAge
+	}
+	return merged
+}
+
+// Middleware returns an http.Handler wrapper enforcing p. This is the
+// "factory" half of the per-route override pattern: call WithOverride to
+// derive a route-scoped CORSPolicy, then Middleware on the result.
+func (p *CORSPolicy) Middleware(next http.Handler) http.Handler {
+	if !p.cfg.Enabled {
+		return next
+	}
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		origin := r.Header.Get("Origin")
-
-		// If no origin header, skip CORS (not a cross-origin request)
 		if origin == "" {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		// Check if origin is allowed
-		allowedOrigin := ""
-		if len(cfg.AllowedOrigins) == 0 {
-			// No allowed origins configured, default to wildcard
-			allowedOrigin = "*"
-		} else {
-			for _, allowedOrig := range cfg.AllowedOrigins {
-				if allowedOrig == "*" || allowedOrig == origin {
-					allowedOrigin = allowedOrig
-					break
-				}
-			}
-		}
+		// The response varies by Origin regardless of whether it matched, so
+		// shared caches must not serve one client's CORS headers to another.
+		header := w.Header()
+		header.Add("Vary", "Origin")
 
-		// If origin not allowed, don't set CORS headers
-		if allowedOrigin == "" {
+		allowOrigin, ok := p.resolveOrigin(origin)
+		if !ok {
 			next.ServeHTTP(w, r)
 			return
 		}
 
-		w.Header().Set("Access-Control-Allow-Origin", allowedOrigin)
-		w.Header().Set("Access-Control-Allow-Methods", methodsStr)
-		w.Header().Set("Access-Control-Allow-Headers", headersStr)
+		header.Set("Access-Control-Allow-Origin", allowOrigin)
+		if p.cfg.AllowCredentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+		if r.Header.Get("Access-Control-Request-Private-Network") == "true" {
+			header.Set("Access-Control-Allow-Private-Network", "true")
+		}
 
 		if r.Method == http.MethodOptions {
-			w.WriteHeader(http.StatusOK)
+			// Access-Control-Allow-Methods/-Headers are only meaningful on a
+			// preflight response, so they're set here rather than on every
+			// response.
+			header.Add("Vary", "Access-Control-Request-Headers")
+			methods, headers, ok := p.preflightResponse(r)
+			if !ok {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			header.Set("Access-Control-Allow-Methods", methods)
+			if headers != "" {
+				header.Set("Access-Control-Allow-Headers", headers)
+			}
+			if p.maxAgeHeader != "" {
+				header.Set("Access-Control-Max-Age", p.maxAgeHeader)
+			}
+			w.WriteHeader(http.StatusNoContent)
 			return
 		}
 
+		if p.exposedHeaders != "" {
+			header.Set("Access-Control-Expose-Headers", p.exposedHeaders)
+		}
 		next.ServeHTTP(w, r)
 	})
 }
 
+// resolveOrigin reports the Access-Control-Allow-Origin value for origin,
+// or false if origin isn't covered by any configured pattern. A matched
+// wildcard pattern resolves to the literal "*", except when AllowCredentials
+// is set, since the Fetch spec forbids a credentialed response from using
+// "*" - the request's Origin is echoed back instead.
+func (p *CORSPolicy) resolveOrigin(origin string) (string, bool) {
+	if len(p.patterns) == 0 {
+		if p.cfg.AllowCredentials {
+			return origin, true
+		}
+		return "*", true
+	}
+	for _, pat := range p.patterns {
+		if !pat.matches(origin) {
+			continue
+		}
+		if pat.wildcard && !p.cfg.AllowCredentials {
+			return "*", true
+		}
+		return origin, true
+	}
+	return "", false
+}
+
+// preflightResponse validates a preflight's requested method and headers
+// against allowedMethods/allowedHeaders and, on success, returns the
+// Access-Control-Allow-Methods/-Headers values to send back: just the
+// method/headers the browser actually asked for, rather than the full
+// configured allow-lists, per the Fetch spec's preflight-response
+// algorithm. A request that doesn't name a method or headers it intends to
+// use falls back to the full configured sets, since there's nothing
+// requested to echo. ok is false if a requested method or header isn't
+// allowed, in which case methods/headers are meaningless.
+func (p *CORSPolicy) preflightResponse(r *http.Request) (methods, headers string, ok bool) {
+	methods = p.methodsHeader
+	if requested := r.Header.Get("Access-Control-Request-Method"); requested != "" {
+		if !p.allowedMethods[strings.ToUpper(requested)] {
+			return "", "", false
+		}
+		methods = requested
+	}
 
+	headers = p.headersHeader
+	if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+		names := strings.Split(requested, ",")
+		for i, h := range names {
+			h = strings.TrimSpace(h)
+			if !p.allowedHeaders[strings.ToLower(h)] {
+				return "", "", false
+			}
+			names[i] = h
+		}
+		headers = strings.Join(names, ", ")
+	}
+
+	return methods, headers, true
+}
+
+func toUpperSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[strings.ToUpper(v)] = true
+	}
+	return set
+}
+
+func toLowerSet(values []string) map[string]bool {
+	set := make(map[string]bool, len(values))
+	for _, v := range values {
+		set[strings.ToLower(v)] = true
+	}
+	return set
+}
+
+// CorsMiddleware applies CORS headers to next according to cfg. It is a
+// convenience wrapper around NewCORSPolicy for callers that don't need
+// per-route overrides; a cfg with an invalid AllowedOrigins pattern is
+// treated as disabled, since this signature has no way to report an error.
+func CorsMiddleware(next http.Handler, cfg config.CORSConfig) http.Handler {
+	policy, err := NewCORSPolicy(cfg)
+	if err != nil {
+		return next
+	}
+	return policy.Middleware(next)
+}
@@ -0,0 +1,302 @@
+package middleware
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/threatfabric-devops/tf-telemetry/internal/config"
+)
+
+const (
+	defaultHMACSkew          = 5 * time.Minute
+	defaultNonceCacheSize    = 10000
+	hmacInstallationIDHeader = "X-Installation-Id"
+	hmacTimestampHeader      = "X-Timestamp"
+	hmacNonceHeader          = "X-Nonce"
+	hmacSignatureHeader      = "X-Signature"
+	hmacInstallationIDMDKey  = "x-installation-id"
+	hmacTimestampMDKey       = "x-timestamp"
+	hmacNonceMDKey           = "x-nonce"
+	hmacSignatureMDKey       = "x-signature"
+)
+
+// SecretStore resolves the shared secret an installation signs its
+// requests with, keyed by InstallationId.
+type SecretStore interface {
+	Secret(installationID string) ([]byte, bool)
+}
+
+// InMemorySecretStore is a SecretStore backed by a fixed map, suitable for
+// tests or a small number of statically-provisioned installations.
+type InMemorySecretStore struct {
+	secrets map[string][]byte
+}
+
+// NewInMemorySecretStore builds an InMemorySecretStore from a map of
+// installation ID to hex-encoded secret, matching config.HMACAuthConfig.Secrets.
+func NewInMemorySecretStore(hexSecrets map[string]string) (*InMemorySecretStore, error) {
+	secrets := make(map[string][]byte, len(hexSecrets))
+	for id, hexSecret := range hexSecrets {
+		secret, err := hex.DecodeString(hexSecret)
+		if err != nil {
+			return nil, err
+		}
+		secrets[id] = secret
+	}
+	return &InMemorySecretStore{secrets: secrets}, nil
+}
+
+// Secret implements SecretStore.
+func (s *InMemorySecretStore) Secret(installationID string) ([]byte, bool) {
+	secret, ok := s.secrets[installationID]
+	return secret, ok
+}
+
+// FileSecretStore is a SecretStore backed by a JSON file mapping
+// installation ID to hex-encoded secret, loaded once at construction.
+type FileSecretStore struct {
+	*InMemorySecretStore
+}
+
+// NewFileSecretStore reads path as a JSON object of installation ID to
+// hex-encoded secret.
+func NewFileSecretStore(path string) (*FileSecretStore, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var hexSecrets map[string]string
+	if err := json.Unmarshal(data, &hexSecrets); err != nil {
+		return nil, err
+	}
+	store, err := NewInMemorySecretStore(hexSecrets)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSecretStore{InMemorySecretStore: store}, nil
+}
+
+// NewSecretStore builds the SecretStore cfg describes, preferring
+// SecretsFile over the inline Secrets map when both are set.
+func NewSecretStore(cfg config.HMACAuthConfig) (SecretStore, error) {
+	if cfg.SecretsFile != "" {
+		return NewFileSecretStore(cfg.SecretsFile)
+	}
+	return NewInMemorySecretStore(cfg.Secrets)
+}
+
+// nonceCache is a fixed-capacity LRU of recently-seen nonces, used to
+// reject a signature replayed within the skew window.
+type nonceCache struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List
+	index    map[string]*list.Element
+}
+
+func newNonceCache(capacity int) *nonceCache {
+	if capacity <= 0 {
+		capacity = defaultNonceCacheSize
+	}
+	return &nonceCache{
+		capacity: capacity,
+		order:    list.New(),
+		index:    make(map[string]*list.Element),
+	}
+}
+
+// claim records key and reports whether it was accepted; false means key
+// was already present, i.e. a replay.
+func (c *nonceCache) claim(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.index[key]; ok {
+		return false
+	}
+	elem := c.order.PushFront(key)
+	c.index[key] = elem
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		if oldest != nil {
+			c.order.Remove(oldest)
+			delete(c.index, oldest.Value.(string))
+		}
+	}
+	return true
+}
+
+// hmacSkewWindow resolves cfg's zero-valued SkewSeconds to its default;
+// newNonceCache does the same for NonceCacheSize.
+func hmacSkewWindow(cfg config.HMACAuthConfig) time.Duration {
+	if cfg.SkewSeconds <= 0 {
+		return defaultHMACSkew
+	}
+	return time.Duration(cfg.SkewSeconds) * time.Second
+}
+
+// hmacSignature computes the signature an installation must present:
+// HMAC-SHA256, under its shared secret, of timestamp+method+path followed
+// by the hex-encoded SHA-256 of body. Including a hash of the body proves
+// the signer saw this exact payload without having to HMAC the (possibly
+// large) body directly.
+func hmacSignature(secret []byte, timestamp, method, path string, body []byte) []byte {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte(method))
+	mac.Write([]byte(path))
+	mac.Write([]byte(hex.EncodeToString(bodyHash[:])))
+	return mac.Sum(nil)
+}
+
+func withinSkew(rawTimestamp string, skew time.Duration) bool {
+	seconds, err := strconv.ParseInt(rawTimestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+	ts := time.Unix(seconds, 0)
+	age := time.Since(ts)
+	if age < 0 {
+		age = -age
+	}
+	return age <= skew
+}
+
+func verifyHMACSignature(store SecretStore, nonces *nonceCache, skew time.Duration, installationID, timestamp, nonce, signatureHex, method, path string, body []byte) bool {
+	if installationID == "" || timestamp == "" || nonce == "" || signatureHex == "" {
+		return false
+	}
+	if !withinSkew(timestamp, skew) {
+		return false
+	}
+	signature, err := hex.DecodeString(signatureHex)
+	if err != nil {
+		return false
+	}
+	secret, ok := store.Secret(installationID)
+	if !ok {
+		return false
+	}
+	expected := hmacSignature(secret, timestamp, method, path, body)
+	if !hmac.Equal(signature, expected) {
+		return false
+	}
+	// Claim the nonce last: an invalid signature shouldn't be able to burn
+	// a legitimate future nonce out of the cache.
+	return nonces.claim(installationID + ":" + nonce)
+}
+
+// HMACAuthHTTP verifies a signature of timestamp+method+path+sha256(body)
+// under the shared secret store resolves for the request's
+// X-Installation-Id, as an alternative to shipping a shared Basic Auth
+// password in an on-device agent binary.
+func HMACAuthHTTP(cfg config.HMACAuthConfig, store SecretStore) func(http.Handler) http.Handler {
+	skew := hmacSkewWindow(cfg)
+	nonces := newNonceCache(cfg.NonceCacheSize)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+
+			installationID := r.Header.Get(hmacInstallationIDHeader)
+			ok := verifyHMACSignature(store, nonces, skew,
+				installationID,
+				r.Header.Get(hmacTimestampHeader),
+				r.Header.Get(hmacNonceHeader),
+				r.Header.Get(hmacSignatureHeader),
+				r.Method, r.URL.Path, body)
+			if !ok {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(ContextWithPrincipal(r.Context(), installationID)))
+		})
+	}
+}
+
+// HMACAuthAttempt adapts HMACAuthHTTP's check into an AuthAttempt for
+// ComposeAuthHTTP.
+func HMACAuthAttempt(cfg config.HMACAuthConfig, store SecretStore) AuthAttempt {
+	skew := hmacSkewWindow(cfg)
+	nonces := newNonceCache(cfg.NonceCacheSize)
+	return func(r *http.Request) (string, bool) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			return "", false
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		installationID := r.Header.Get(hmacInstallationIDHeader)
+		if !verifyHMACSignature(store, nonces, skew,
+			installationID,
+			r.Header.Get(hmacTimestampHeader),
+			r.Header.Get(hmacNonceHeader),
+			r.Header.Get(hmacSignatureHeader),
+			r.Method, r.URL.Path, body) {
+			return "", false
+		}
+		return installationID, true
+	}
+}
+
+// HMACAuthUnary is the gRPC analogue of HMACAuthHTTP. Since a unary
+// interceptor only sees the decoded message rather than raw wire bytes,
+// the signed "body" is the canonical protobuf encoding of req.
+func HMACAuthUnary(cfg config.HMACAuthConfig, store SecretStore) grpc.UnaryServerInterceptor {
+	skew := hmacSkewWindow(cfg)
+	nonces := newNonceCache(cfg.NonceCacheSize)
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		md, ok := metadata.FromIncomingContext(ctx)
+		if !ok {
+			return nil, status.Error(codes.Unauthenticated, "missing metadata")
+		}
+		message, ok := req.(proto.Message)
+		if !ok {
+			return nil, status.Error(codes.Unauthenticated, "request does not support HMAC verification")
+		}
+		body, err := proto.Marshal(message)
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, "failed to canonicalize request")
+		}
+
+		installationID := mdValue(md, hmacInstallationIDMDKey)
+		if !verifyHMACSignature(store, nonces, skew,
+			installationID,
+			mdValue(md, hmacTimestampMDKey),
+			mdValue(md, hmacNonceMDKey),
+			mdValue(md, hmacSignatureMDKey),
+			"grpc", info.FullMethod, body) {
+			return nil, status.Error(codes.Unauthenticated, "invalid HMAC signature")
+		}
+		return handler(ContextWithPrincipal(ctx, installationID), req)
+	}
+}
+
+func mdValue(md metadata.MD, key string) string {
+	values := md.Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
@@ -0,0 +1,290 @@
+package middleware
+
+import (
+	"context"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/threatfabric-devops/tf-telemetry/internal/config"
+	"github.com/threatfabric-devops/tf-telemetry/internal/gen/pb"
+)
+
+const hmacTestInstallationID = "device-1"
+
+func hmacTestStore(t *testing.T) (SecretStore, []byte) {
+	t.Helper()
+	secret := []byte("super-secret-key")
+	store, err := NewInMemorySecretStore(map[string]string{
+		hmacTestInstallationID: hex.EncodeToString(secret),
+	})
+	if err != nil {
+		t.Fatalf("NewInMemorySecretStore: %v", err)
+	}
+	return store, secret
+}
+
+func signHMACRequest(t *testing.T, req *http.Request, secret []byte, timestamp time.Time, nonce string, body []byte) {
+	t.Helper()
+	ts := strconv.FormatInt(timestamp.Unix(), 10)
+	sig := hmacSignature(secret, ts, req.Method, req.URL.Path, body)
+	req.Header.Set(hmacInstallationIDHeader, hmacTestInstallationID)
+	req.Header.Set(hmacTimestampHeader, ts)
+	req.Header.Set(hmacNonceHeader, nonce)
+	req.Header.Set(hmacSignatureHeader, hex.EncodeToString(sig))
+}
+
+func TestHMACAuthHTTP_Valid(t *testing.T) {
+	store, secret := hmacTestStore(t)
+	cfg := config.HMACAuthConfig{Enabled: true}
+
+	handler := HMACAuthHTTP(cfg, store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := PrincipalFromContext(r.Context())
+		if !ok || principal != hmacTestInstallationID {
+			t.Errorf("expected principal %q, got %q (ok=%v)", hmacTestInstallationID, principal, ok)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/telemetry", nil)
+	signHMACRequest(t, req, secret, time.Now(), "nonce-1", nil)
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+}
+
+func TestHMACAuthHTTP_InvalidSignature(t *testing.T) {
+	store, _ := hmacTestStore(t)
+	cfg := config.HMACAuthConfig{Enabled: true}
+
+	handler := HMACAuthHTTP(cfg, store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("handler should not be called for an invalid signature")
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/telemetry", nil)
+	signHMACRequest(t, req, []byte("wrong-secret"), time.Now(), "nonce-1", nil)
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+}
+
+func TestHMACAuthHTTP_MissingHeaders(t *testing.T) {
+	store, _ := hmacTestStore(t)
+	cfg := config.HMACAuthConfig{Enabled: true}
+
+	handler := HMACAuthHTTP(cfg, store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("handler should not be called without signature headers")
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/telemetry", nil)
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+}
+
+func TestHMACAuthHTTP_UnknownInstallation(t *testing.T) {
+	store, err := NewInMemorySecretStore(nil)
+	if err != nil {
+		t.Fatalf("NewInMemorySecretStore: %v", err)
+	}
+	cfg := config.HMACAuthConfig{Enabled: true}
+
+	handler := HMACAuthHTTP(cfg, store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("handler should not be called for an unknown installation")
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/telemetry", nil)
+	signHMACRequest(t, req, []byte("some-secret"), time.Now(), "nonce-1", nil)
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+}
+
+func TestHMACAuthHTTP_TimestampOutsideSkew(t *testing.T) {
+	store, secret := hmacTestStore(t)
+	cfg := config.HMACAuthConfig{Enabled: true, SkewSeconds: 30}
+
+	handler := HMACAuthHTTP(cfg, store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("handler should not be called for a stale timestamp")
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/telemetry", nil)
+	signHMACRequest(t, req, secret, time.Now().Add(-time.Hour), "nonce-1", nil)
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a timestamp outside the skew window, got %d", w.Code)
+	}
+}
+
+func TestHMACAuthHTTP_ReplayedNonceRejected(t *testing.T) {
+	store, secret := hmacTestStore(t)
+	cfg := config.HMACAuthConfig{Enabled: true}
+
+	handler := HMACAuthHTTP(cfg, store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	now := time.Now()
+	req1 := httptest.NewRequest(http.MethodPost, "/v1/telemetry", nil)
+	signHMACRequest(t, req1, secret, now, "replayed-nonce", nil)
+	w1 := httptest.NewRecorder()
+	handler.ServeHTTP(w1, req1)
+	if w1.Code != http.StatusOK {
+		t.Fatalf("expected first request to succeed, got %d", w1.Code)
+	}
+
+	req2 := httptest.NewRequest(http.MethodPost, "/v1/telemetry", nil)
+	signHMACRequest(t, req2, secret, now, "replayed-nonce", nil)
+	w2 := httptest.NewRecorder()
+	handler.ServeHTTP(w2, req2)
+	if w2.Code != http.StatusUnauthorized {
+		t.Fatalf("expected replayed nonce to be rejected, got %d", w2.Code)
+	}
+}
+
+func TestHMACAuthHTTP_BodyHashCoversPayload(t *testing.T) {
+	store, secret := hmacTestStore(t)
+	cfg := config.HMACAuthConfig{Enabled: true}
+
+	handler := HMACAuthHTTP(cfg, store)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/telemetry", strings.NewReader(`{"a":1}`))
+	signHMACRequest(t, req, secret, time.Now(), "nonce-body", []byte(`{"a":2}`)) // signature covers a different body
+	w := httptest.NewRecorder()
+
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 when the signature doesn't match the actual body, got %d", w.Code)
+	}
+}
+
+func TestHMACAuthUnary_Valid(t *testing.T) {
+	store, secret := hmacTestStore(t)
+	cfg := config.HMACAuthConfig{Enabled: true}
+	interceptor := HMACAuthUnary(cfg, store)
+
+	req := &pb.TelemetryPacket{}
+	body, err := proto.Marshal(req)
+	if err != nil {
+		t.Fatalf("proto.Marshal: %v", err)
+	}
+	fullMethod := "/tf.telemetry.Collector/SendTelemetry"
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	sig := hmacSignature(secret, ts, "grpc", fullMethod, body)
+
+	md := metadata.Pairs(
+		hmacInstallationIDMDKey, hmacTestInstallationID,
+		hmacTimestampMDKey, ts,
+		hmacNonceMDKey, "nonce-grpc-1",
+		hmacSignatureMDKey, hex.EncodeToString(sig),
+	)
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+
+	var gotPrincipal string
+	handler := func(ctx context.Context, req any) (any, error) {
+		principal, _ := PrincipalFromContext(ctx)
+		gotPrincipal = principal
+		return "ok", nil
+	}
+
+	_, err = interceptor(ctx, req, &grpc.UnaryServerInfo{FullMethod: fullMethod}, handler)
+	if err != nil {
+		t.Fatalf("expected success, got %v", err)
+	}
+	if gotPrincipal != hmacTestInstallationID {
+		t.Fatalf("expected principal %q, got %q", hmacTestInstallationID, gotPrincipal)
+	}
+}
+
+func TestHMACAuthUnary_InvalidSignature(t *testing.T) {
+	store, _ := hmacTestStore(t)
+	cfg := config.HMACAuthConfig{Enabled: true}
+	interceptor := HMACAuthUnary(cfg, store)
+
+	req := &pb.TelemetryPacket{}
+	fullMethod := "/tf.telemetry.Collector/SendTelemetry"
+	md := metadata.Pairs(
+		hmacInstallationIDMDKey, hmacTestInstallationID,
+		hmacTimestampMDKey, strconv.FormatInt(time.Now().Unix(), 10),
+		hmacNonceMDKey, "nonce-grpc-2",
+		hmacSignatureMDKey, hex.EncodeToString([]byte("not-a-real-signature")),
+	)
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+
+	handler := func(ctx context.Context, req any) (any, error) { return "ok", nil }
+
+	_, err := interceptor(ctx, req, &grpc.UnaryServerInfo{FullMethod: fullMethod}, handler)
+	if err == nil {
+		t.Fatal("expected an error for an invalid signature")
+	}
+	if st, ok := status.FromError(err); !ok || st.Code() != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated, got %v", err)
+	}
+}
+
+func TestHMACAuthUnary_NoMetadata(t *testing.T) {
+	store, _ := hmacTestStore(t)
+	interceptor := HMACAuthUnary(config.HMACAuthConfig{Enabled: true}, store)
+	handler := func(ctx context.Context, req any) (any, error) { return "ok", nil }
+
+	_, err := interceptor(context.Background(), &pb.TelemetryPacket{}, &grpc.UnaryServerInfo{}, handler)
+	if err == nil {
+		t.Fatal("expected an error without metadata")
+	}
+}
+
+func TestNonceCache_EvictsOldestBeyondCapacity(t *testing.T) {
+	cache := newNonceCache(2)
+
+	if !cache.claim("a") {
+		t.Fatal("expected first claim of a to succeed")
+	}
+	if !cache.claim("b") {
+		t.Fatal("expected first claim of b to succeed")
+	}
+	if !cache.claim("c") {
+		t.Fatal("expected first claim of c to succeed")
+	}
+	// "a" should have been evicted to make room for "c", so it can be
+	// claimed again without being treated as a replay.
+	if !cache.claim("a") {
+		t.Fatal("expected a to be claimable again after eviction")
+	}
+	// Re-admitting "a" evicted "b", the oldest remaining entry, so "c" is the
+	// other key still cached.
+	if cache.claim("c") {
+		t.Fatal("expected c to still be cached and rejected as a replay")
+	}
+}
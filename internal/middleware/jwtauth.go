@@ -0,0 +1,301 @@
+package middleware
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/threatfabric-devops/tf-telemetry/internal/config"
+)
+
+const (
+	defaultInstallationIDClaim = "installation_id"
+	defaultCustomerIDClaim     = "customer_id"
+)
+
+// BearerClaims holds the identity and authorization scopes extracted from a
+// token verified by JWKSVerifier or JWTVerifier. It is attached to the
+// request context so downstream stages (e.g. RateLimitUnary) can use the
+// subject - or, if the token carries one, a custom installation-ID claim -
+// as an installation-ID source instead of only gating the request.
+type BearerClaims struct {
+	Subject string
+	Scopes  []string
+	// InstallationID is set by JWTVerifier from config.JWTAuthConfig's
+	// InstallationIDClaim when the token carries it; empty otherwise.
+	InstallationID string
+	// CustomerID is set by JWTVerifier from config.JWTAuthConfig's
+	// CustomerIDClaim when the token carries it; empty otherwise. It takes
+	// precedence over InstallationID/Subject as a rate-limit and downstream
+	// identity key, since it names the tenant the installation belongs to
+	// rather than the installation itself.
+	CustomerID string
+}
+
+type claimsKey struct{}
+
+// ContextWithClaims attaches the verified bearer claims to ctx.
+func ContextWithClaims(ctx context.Context, claims BearerClaims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// ClaimsFromContext returns the bearer claims attached by JWTAuthHTTP or
+// JWTAuthUnary, if any.
+func ClaimsFromContext(ctx context.Context) (BearerClaims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(BearerClaims)
+	return claims, ok
+}
+
+// Typed verification failures JWTAuthHTTP/JWTAuthUnary return, so callers
+// can errors.Is against a specific cause instead of matching error strings.
+var (
+	ErrMissingBearerToken   = errors.New("missing bearer token")
+	ErrTokenExpired         = errors.New("token expired")
+	ErrTokenNotYetValid     = errors.New("token not yet valid")
+	ErrInvalidIssuer        = errors.New("unexpected issuer")
+	ErrInvalidAudience      = errors.New("unexpected audience")
+	ErrInvalidSignature     = errors.New("invalid token signature")
+	ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+)
+
+// JWTVerifier validates bearer tokens with github.com/golang-jwt/jwt/v5,
+// supporting HS256/HS384/HS512 against a shared secret and RS256/ES256
+// against a PEM-encoded public key or a JWKS endpoint. Unlike JWKSVerifier
+// (hand-rolled), signature and standard-claim verification here is
+// delegated to the library; JWTVerifier only resolves key material and
+// translates jwt/v5's errors into this package's typed ones.
+type JWTVerifier struct {
+	cfg             config.JWTAuthConfig
+	claim           string
+	customerIDClaim string
+	methods         []string
+
+	hmacKey []byte
+	rsaKey  *rsa.PublicKey
+	ecKey   *ecdsa.PublicKey
+	jwks    map[string]*rsa.PublicKey
+}
+
+// NewJWTVerifier builds a verifier from cfg. A JWKSURL is fetched once,
+// synchronously, so an unreachable endpoint surfaces at startup.
+func NewJWTVerifier(cfg config.JWTAuthConfig) (*JWTVerifier, error) {
+	v := &JWTVerifier{cfg: cfg, claim: cfg.InstallationIDClaim, customerIDClaim: cfg.CustomerIDClaim}
+	if v.claim == "" {
+		v.claim = defaultInstallationIDClaim
+	}
+	if v.customerIDClaim == "" {
+		v.customerIDClaim = defaultCustomerIDClaim
+	}
+
+	if cfg.HMACSecret != "" {
+		v.hmacKey = []byte(cfg.HMACSecret)
+		v.methods = append(v.methods, "HS256", "HS384", "HS512")
+	}
+	if cfg.RSAPublicKey != "" {
+		key, err := jwt.ParseRSAPublicKeyFromPEM([]byte(cfg.RSAPublicKey))
+		if err != nil {
+			return nil, fmt.Errorf("parse RSA public key: %w", err)
+		}
+		v.rsaKey = key
+	}
+	if cfg.ECPublicKey != "" {
+		key, err := jwt.ParseECPublicKeyFromPEM([]byte(cfg.ECPublicKey))
+		if err != nil {
+			return nil, fmt.Errorf("parse EC public key: %w", err)
+		}
+		v.ecKey = key
+		v.methods = append(v.methods, "ES256")
+	}
+	if cfg.JWKSURL != "" {
+		keys, err := fetchRSAJWKS(&http.Client{}, cfg.JWKSURL)
+		if err != nil {
+			return nil, err
+		}
+		v.jwks = keys
+	}
+	if v.rsaKey != nil || v.jwks != nil {
+		v.methods = append(v.methods, "RS256")
+	}
+
+	return v, nil
+}
+
+func (v *JWTVerifier) keyFunc(token *jwt.Token) (any, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if v.hmacKey == nil {
+			return nil, ErrUnsupportedAlgorithm
+		}
+		return v.hmacKey, nil
+	case *jwt.SigningMethodRSA:
+		if kid, _ := token.Header["kid"].(string); kid != "" && v.jwks != nil {
+			if key, ok := v.jwks[kid]; ok {
+				return key, nil
+			}
+		}
+		if v.rsaKey != nil {
+			return v.rsaKey, nil
+		}
+		return nil, ErrUnsupportedAlgorithm
+	case *jwt.SigningMethodECDSA:
+		if v.ecKey == nil {
+			return nil, ErrUnsupportedAlgorithm
+		}
+		return v.ecKey, nil
+	default:
+		return nil, ErrUnsupportedAlgorithm
+	}
+}
+
+// Verify checks token's signature and standard claims, returning the
+// resolved subject/installation-ID/customer-ID claims on success.
+func (v *JWTVerifier) Verify(tokenString string) (BearerClaims, error) {
+	parserOpts := []jwt.ParserOption{jwt.WithValidMethods(v.methods)}
+	if v.cfg.Issuer != "" {
+		parserOpts = append(parserOpts, jwt.WithIssuer(v.cfg.Issuer))
+	}
+	if v.cfg.Audience != "" {
+		parserOpts = append(parserOpts, jwt.WithAudience(v.cfg.Audience))
+	}
+	if v.cfg.ClockSkewSeconds > 0 {
+		parserOpts = append(parserOpts, jwt.WithLeeway(time.Duration(v.cfg.ClockSkewSeconds)*time.Second))
+	}
+
+	token, err := jwt.Parse(tokenString, v.keyFunc, parserOpts...)
+	if err != nil {
+		return BearerClaims{}, classifyJWTError(err)
+	}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return BearerClaims{}, ErrInvalidSignature
+	}
+
+	subject, _ := claims.GetSubject()
+	installationID, _ := claims[v.claim].(string)
+	customerID, _ := claims[v.customerIDClaim].(string)
+	return BearerClaims{Subject: subject, InstallationID: installationID, CustomerID: customerID}, nil
+}
+
+// classifyJWTError translates jwt/v5's sentinel errors into this package's
+// typed ones so callers can errors.Is against e.g. ErrTokenExpired without
+// depending on the jwt/v5 package directly.
+func classifyJWTError(err error) error {
+	switch {
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return fmt.Errorf("%w: %v", ErrTokenExpired, err)
+	case errors.Is(err, jwt.ErrTokenNotValidYet):
+		return fmt.Errorf("%w: %v", ErrTokenNotYetValid, err)
+	case errors.Is(err, jwt.ErrTokenInvalidIssuer):
+		return fmt.Errorf("%w: %v", ErrInvalidIssuer, err)
+	case errors.Is(err, jwt.ErrTokenInvalidAudience):
+		return fmt.Errorf("%w: %v", ErrInvalidAudience, err)
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid):
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	case errors.Is(err, ErrUnsupportedAlgorithm):
+		return ErrUnsupportedAlgorithm
+	default:
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+}
+
+// JWTAuthHTTP wraps an HTTP handler with bearer-token validation against v,
+// attaching the resolved claims to the request context (via
+// ContextWithClaims and ContextWithPrincipal) on success.
+func JWTAuthHTTP(v *JWTVerifier) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := bearerToken(r.Header.Get("Authorization"))
+			if !ok {
+				writeJWTAuthError(w, ErrMissingBearerToken)
+				return
+			}
+			claims, err := v.Verify(token)
+			if err != nil {
+				writeJWTAuthError(w, err)
+				return
+			}
+			ctx := ContextWithClaims(r.Context(), claims)
+			ctx = ContextWithPrincipal(ctx, claims.Subject)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func writeJWTAuthError(w http.ResponseWriter, err error) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="Restricted"`)
+	http.Error(w, err.Error(), http.StatusUnauthorized)
+}
+
+// JWTAuthUnary is the gRPC analogue of JWTAuthHTTP.
+func JWTAuthUnary(v *JWTVerifier) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		md, ok := metadata.FromIncomingContext(ctx)
+		if !ok {
+			return nil, status.Error(codes.Unauthenticated, ErrMissingBearerToken.Error())
+		}
+		authHeaders := md.Get("authorization")
+		if len(authHeaders) == 0 {
+			return nil, status.Error(codes.Unauthenticated, ErrMissingBearerToken.Error())
+		}
+		token, ok := bearerToken(authHeaders[0])
+		if !ok {
+			return nil, status.Error(codes.Unauthenticated, ErrMissingBearerToken.Error())
+		}
+		claims, err := v.Verify(token)
+		if err != nil {
+			return nil, status.Error(codes.Unauthenticated, err.Error())
+		}
+		newCtx := ContextWithClaims(ctx, claims)
+		newCtx = ContextWithPrincipal(newCtx, claims.Subject)
+		return handler(newCtx, req)
+	}
+}
+
+// JWTAuthAttempt adapts JWTAuthHTTP's check into an AuthAttempt for
+// ComposeAuthHTTP, so config.AuthConfig can select jwt alongside basic.
+func JWTAuthAttempt(v *JWTVerifier) AuthAttempt {
+	return func(r *http.Request) (string, bool) {
+		token, ok := bearerToken(r.Header.Get("Authorization"))
+		if !ok {
+			return "", false
+		}
+		claims, err := v.Verify(token)
+		if err != nil {
+			return "", false
+		}
+		return claims.Subject, true
+	}
+}
+
+// JWTAuthAttemptGRPC is the gRPC analogue of JWTAuthAttempt.
+func JWTAuthAttemptGRPC(v *JWTVerifier) GRPCAuthAttempt {
+	return func(ctx context.Context) (string, bool) {
+		md, ok := metadata.FromIncomingContext(ctx)
+		if !ok {
+			return "", false
+		}
+		authHeaders := md.Get("authorization")
+		if len(authHeaders) == 0 {
+			return "", false
+		}
+		token, ok := bearerToken(authHeaders[0])
+		if !ok {
+			return "", false
+		}
+		claims, err := v.Verify(token)
+		if err != nil {
+			return "", false
+		}
+		return claims.Subject, true
+	}
+}
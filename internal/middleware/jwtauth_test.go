@@ -0,0 +1,291 @@
+package middleware
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/threatfabric-devops/tf-telemetry/internal/config"
+)
+
+func rsaPublicKeyPEM(t *testing.T, key *rsa.PublicKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func ecPublicKeyPEM(t *testing.T, key *ecdsa.PublicKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatalf("MarshalPKIXPublicKey: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func signJWT(t *testing.T, method jwt.SigningMethod, key any, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatalf("SignedString: %v", err)
+	}
+	return signed
+}
+
+func TestJWTVerifier_HS256Valid(t *testing.T) {
+	v, err := NewJWTVerifier(config.JWTAuthConfig{HMACSecret: "shared-secret"})
+	if err != nil {
+		t.Fatalf("NewJWTVerifier: %v", err)
+	}
+
+	token := signJWT(t, jwt.SigningMethodHS256, []byte("shared-secret"), jwt.MapClaims{
+		"sub":             "user-1",
+		"installation_id": "device-1",
+		"exp":             time.Now().Add(time.Hour).Unix(),
+	})
+
+	claims, err := v.Verify(token)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if claims.Subject != "user-1" || claims.InstallationID != "device-1" {
+		t.Fatalf("unexpected claims: %+v", claims)
+	}
+}
+
+func TestJWTVerifier_RS256ValidViaPEM(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	v, err := NewJWTVerifier(config.JWTAuthConfig{RSAPublicKey: rsaPublicKeyPEM(t, &key.PublicKey)})
+	if err != nil {
+		t.Fatalf("NewJWTVerifier: %v", err)
+	}
+
+	token := signJWT(t, jwt.SigningMethodRS256, key, jwt.MapClaims{
+		"sub": "user-rsa",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+
+	claims, err := v.Verify(token)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if claims.Subject != "user-rsa" {
+		t.Fatalf("expected subject user-rsa, got %q", claims.Subject)
+	}
+}
+
+func TestJWTVerifier_ES256Valid(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	v, err := NewJWTVerifier(config.JWTAuthConfig{ECPublicKey: ecPublicKeyPEM(t, &key.PublicKey)})
+	if err != nil {
+		t.Fatalf("NewJWTVerifier: %v", err)
+	}
+
+	token := signJWT(t, jwt.SigningMethodES256, key, jwt.MapClaims{
+		"sub": "user-ec",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+
+	claims, err := v.Verify(token)
+	if err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+	if claims.Subject != "user-ec" {
+		t.Fatalf("expected subject user-ec, got %q", claims.Subject)
+	}
+}
+
+func TestJWTVerifier_Expired(t *testing.T) {
+	v, err := NewJWTVerifier(config.JWTAuthConfig{HMACSecret: "shared-secret"})
+	if err != nil {
+		t.Fatalf("NewJWTVerifier: %v", err)
+	}
+	token := signJWT(t, jwt.SigningMethodHS256, []byte("shared-secret"), jwt.MapClaims{
+		"sub": "user-1",
+		"exp": time.Now().Add(-time.Hour).Unix(),
+	})
+
+	_, err = v.Verify(token)
+	if !errors.Is(err, ErrTokenExpired) {
+		t.Fatalf("expected ErrTokenExpired, got %v", err)
+	}
+}
+
+func TestJWTVerifier_InvalidIssuer(t *testing.T) {
+	v, err := NewJWTVerifier(config.JWTAuthConfig{HMACSecret: "shared-secret", Issuer: "expected-issuer"})
+	if err != nil {
+		t.Fatalf("NewJWTVerifier: %v", err)
+	}
+	token := signJWT(t, jwt.SigningMethodHS256, []byte("shared-secret"), jwt.MapClaims{
+		"sub": "user-1",
+		"iss": "other-issuer",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+
+	_, err = v.Verify(token)
+	if !errors.Is(err, ErrInvalidIssuer) {
+		t.Fatalf("expected ErrInvalidIssuer, got %v", err)
+	}
+}
+
+func TestJWTVerifier_WrongSecret(t *testing.T) {
+	v, err := NewJWTVerifier(config.JWTAuthConfig{HMACSecret: "shared-secret"})
+	if err != nil {
+		t.Fatalf("NewJWTVerifier: %v", err)
+	}
+	token := signJWT(t, jwt.SigningMethodHS256, []byte("wrong-secret"), jwt.MapClaims{
+		"sub": "user-1",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+
+	_, err = v.Verify(token)
+	if !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected ErrInvalidSignature, got %v", err)
+	}
+}
+
+func TestJWTAuthHTTP_InjectsPrincipalAndClaims(t *testing.T) {
+	v, err := NewJWTVerifier(config.JWTAuthConfig{HMACSecret: "shared-secret"})
+	if err != nil {
+		t.Fatalf("NewJWTVerifier: %v", err)
+	}
+	token := signJWT(t, jwt.SigningMethodHS256, []byte("shared-secret"), jwt.MapClaims{
+		"sub":             "user-1",
+		"installation_id": "device-1",
+		"exp":             time.Now().Add(time.Hour).Unix(),
+	})
+
+	handler := JWTAuthHTTP(v)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := PrincipalFromContext(r.Context())
+		if !ok || principal != "user-1" {
+			t.Errorf("expected principal user-1, got %q (ok=%v)", principal, ok)
+		}
+		claims, ok := ClaimsFromContext(r.Context())
+		if !ok || claims.InstallationID != "device-1" {
+			t.Errorf("expected installation_id claim device-1, got %+v (ok=%v)", claims, ok)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/telemetry", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", w.Code)
+	}
+}
+
+func TestJWTAuthHTTP_MissingToken(t *testing.T) {
+	v, err := NewJWTVerifier(config.JWTAuthConfig{HMACSecret: "shared-secret"})
+	if err != nil {
+		t.Fatalf("NewJWTVerifier: %v", err)
+	}
+	handler := JWTAuthHTTP(v)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("handler should not be called without a bearer token")
+	}))
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/telemetry", nil)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", w.Code)
+	}
+}
+
+func TestJWTAuthUnary_Valid(t *testing.T) {
+	v, err := NewJWTVerifier(config.JWTAuthConfig{HMACSecret: "shared-secret"})
+	if err != nil {
+		t.Fatalf("NewJWTVerifier: %v", err)
+	}
+	token := signJWT(t, jwt.SigningMethodHS256, []byte("shared-secret"), jwt.MapClaims{
+		"sub": "user-1",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+
+	interceptor := JWTAuthUnary(v)
+	md := metadata.Pairs("authorization", "Bearer "+token)
+	ctx := metadata.NewIncomingContext(context.Background(), md)
+
+	var gotPrincipal string
+	handler := func(ctx context.Context, req any) (any, error) {
+		principal, _ := PrincipalFromContext(ctx)
+		gotPrincipal = principal
+		return "ok", nil
+	}
+
+	_, err = interceptor(ctx, nil, &grpc.UnaryServerInfo{}, handler)
+	if err != nil {
+		t.Fatalf("expected success, got %v", err)
+	}
+	if gotPrincipal != "user-1" {
+		t.Fatalf("expected principal user-1, got %q", gotPrincipal)
+	}
+}
+
+func TestJWTAuthUnary_NoMetadata(t *testing.T) {
+	v, err := NewJWTVerifier(config.JWTAuthConfig{HMACSecret: "shared-secret"})
+	if err != nil {
+		t.Fatalf("NewJWTVerifier: %v", err)
+	}
+	interceptor := JWTAuthUnary(v)
+	handler := func(ctx context.Context, req any) (any, error) { return "ok", nil }
+
+	_, err = interceptor(context.Background(), nil, &grpc.UnaryServerInfo{}, handler)
+	if st, ok := status.FromError(err); !ok || st.Code() != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated, got %v", err)
+	}
+}
+
+func TestAuthConfig_OrderedSchemes(t *testing.T) {
+	cases := []struct {
+		name string
+		cfg  config.AuthConfig
+		want []string
+	}{
+		{"default both", config.AuthConfig{}, []string{"jwt", "basic"}},
+		{"basic only", config.AuthConfig{Mode: "basic"}, []string{"basic"}},
+		{"jwt only", config.AuthConfig{Mode: "jwt"}, []string{"jwt"}},
+		{"explicit precedence", config.AuthConfig{Mode: "both", Precedence: []string{"basic", "jwt"}}, []string{"basic", "jwt"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := tc.cfg.OrderedSchemes()
+			if len(got) != len(tc.want) {
+				t.Fatalf("expected %v, got %v", tc.want, got)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Fatalf("expected %v, got %v", tc.want, got)
+				}
+			}
+		})
+	}
+}
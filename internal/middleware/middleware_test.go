@@ -3,6 +3,7 @@ package middleware
 import (
 	"context"
 	"encoding/base64"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -128,6 +129,296 @@ func TestRateLimiter_Allow_DifferentKeys(t *testing.T) {
 	}
 }
 
+func TestRateLimiter_Close_StopsJanitor(t *testing.T) {
+	cfg := config.RateLimitConfig{
+		Enabled:           true,
+		RequestsPerSecond: 10,
+		Burst:             10,
+		IdleTTLSeconds:    1,
+	}
+	limiter := NewRateLimiter(cfg)
+
+	done := make(chan struct{})
+	go func() {
+		_ = limiter.Close()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected Close to stop the janitor and return")
+	}
+}
+
+func TestRateLimiter_Close_Nil(t *testing.T) {
+	var limiter *RateLimiter
+	if err := limiter.Close(); err != nil {
+		t.Fatalf("expected nil limiter Close to be a no-op, got %v", err)
+	}
+}
+
+func TestRateLimiter_EvictIdle(t *testing.T) {
+	cfg := config.RateLimitConfig{
+		Enabled:           true,
+		RequestsPerSecond: 10,
+		Burst:             10,
+		IdleTTLSeconds:    1,
+	}
+	limiter := NewRateLimiter(cfg)
+	defer limiter.Close()
+
+	limiter.Allow("stale-key")
+	if limiter.activeCount.Load() != 1 {
+		t.Fatalf("expected 1 active key, got %d", limiter.activeCount.Load())
+	}
+
+	// Back-date the entry's lastSeen so it looks idle without sleeping past
+	// idleTTL in the test.
+	entry := limiter.entryForKey("stale-key")
+	entry.lastSeen.Store(time.Now().Add(-time.Hour).UnixNano())
+
+	limiter.evictIdle()
+
+	if limiter.activeCount.Load() != 0 {
+		t.Fatalf("expected the idle key to be evicted, active count = %d", limiter.activeCount.Load())
+	}
+}
+
+func TestRateLimiter_AdaptiveDecreasesUnderOverload(t *testing.T) {
+	cfg := config.RateLimitConfig{
+		Enabled:           true,
+		RequestsPerSecond: 100,
+		Burst:             100,
+		Adaptive: config.AdaptiveRateLimitConfig{
+			Enabled:               true,
+			InflightHighWatermark: 0,
+			MinRateFraction:       0.1,
+			DecreaseFactor:        0.5,
+			RecoveryStep:          0.05,
+		},
+	}
+	limiter := NewRateLimiter(cfg)
+	defer limiter.Close()
+
+	limiter.Allow("key1")
+	limiter.inflight.Store(5) // simulate overload without real concurrent callers
+
+	limiter.adjustAdaptiveFraction()
+
+	limiter.fractionMu.Lock()
+	fraction := limiter.fraction
+	limiter.fractionMu.Unlock()
+	if fraction != 0.5 {
+		t.Fatalf("expected fraction to halve to 0.5, got %v", fraction)
+	}
+
+	limiter.inflight.Store(0)
+	limiter.adjustAdaptiveFraction()
+
+	limiter.fractionMu.Lock()
+	fraction = limiter.fraction
+	limiter.fractionMu.Unlock()
+	if fraction != 0.55 {
+		t.Fatalf("expected fraction to recover to 0.55, got %v", fraction)
+	}
+}
+
+func TestRateLimiter_ShardedKeysIndependentlyLimited(t *testing.T) {
+	cfg := config.RateLimitConfig{
+		Enabled:           true,
+		RequestsPerSecond: 1,
+		Burst:             1,
+	}
+	limiter := NewRateLimiter(cfg)
+	defer limiter.Close()
+
+	keys := []string{"a", "b", "c", "installation-1", "installation-2"}
+	for _, key := range keys {
+		if !limiter.Allow(key) {
+			t.Fatalf("expected first request for key %q to be allowed", key)
+		}
+		if limiter.Allow(key) {
+			t.Fatalf("expected second request for key %q to be rate limited", key)
+		}
+	}
+	if limiter.activeCount.Load() != int64(len(keys)) {
+		t.Fatalf("expected %d active keys, got %d", len(keys), limiter.activeCount.Load())
+	}
+}
+
+// sameShardKeys returns count distinct keys (derived from prefix) that all
+// hash into the same shard, so a shard's MaxKeys cap can be exercised
+// deterministically.
+func sameShardKeys(t *testing.T, limiter *RateLimiter, prefix string, count int) []string {
+	t.Helper()
+	target := limiter.shardFor(prefix)
+	keys := make([]string, 0, count)
+	for i := 0; len(keys) < count; i++ {
+		key := fmt.Sprintf("%s-%d", prefix, i)
+		if limiter.shardFor(key) == target {
+			keys = append(keys, key)
+		}
+		if i > 100_000 {
+			t.Fatalf("failed to find %d keys hashing to the same shard", count)
+		}
+	}
+	return keys
+}
+
+func TestRateLimiter_LRUEvictsLeastRecentlyUsed(t *testing.T) {
+	cfg := config.RateLimitConfig{
+		Enabled:           true,
+		RequestsPerSecond: 10,
+		Burst:             10,
+		MaxKeys:           rateLimiterShardCount * 2, // 2 entries allowed per shard
+	}
+	limiter := NewRateLimiter(cfg)
+	defer limiter.Close()
+
+	keys := sameShardKeys(t, limiter, "lru", 3)
+
+	limiter.Allow(keys[0])
+	limiter.Allow(keys[1])
+	limiter.Allow(keys[0]) // touch keys[0] again so keys[1] becomes the LRU entry
+
+	// Inserting a third key over the shard's 2-entry cap evicts keys[1].
+	limiter.Allow(keys[2])
+
+	shard := limiter.shardFor(keys[1])
+	shard.mu.Lock()
+	_, stillPresent := shard.entries[keys[1]]
+	shard.mu.Unlock()
+	if stillPresent {
+		t.Fatalf("expected least-recently-used key %q to be evicted", keys[1])
+	}
+
+	for _, key := range []string{keys[0], keys[2]} {
+		shard := limiter.shardFor(key)
+		shard.mu.Lock()
+		_, ok := shard.entries[key]
+		shard.mu.Unlock()
+		if !ok {
+			t.Fatalf("expected recently-used key %q to remain tracked", key)
+		}
+	}
+}
+
+func TestRateLimiter_EvictedKeyGetsFreshBurst(t *testing.T) {
+	cfg := config.RateLimitConfig{
+		Enabled:           true,
+		RequestsPerSecond: 1,
+		Burst:             1,
+		MaxKeys:           rateLimiterShardCount, // 1 entry allowed per shard
+	}
+	limiter := NewRateLimiter(cfg)
+	defer limiter.Close()
+
+	keys := sameShardKeys(t, limiter, "burst", 2)
+
+	if !limiter.Allow(keys[0]) {
+		t.Fatalf("expected first request for %q to be allowed", keys[0])
+	}
+	if limiter.Allow(keys[0]) {
+		t.Fatalf("expected second request for %q to be rate limited", keys[0])
+	}
+
+	// The shard can only hold 1 entry, so admitting keys[1] evicts keys[0].
+	if !limiter.Allow(keys[1]) {
+		t.Fatalf("expected first request for %q to be allowed", keys[1])
+	}
+
+	// keys[0] was evicted, so it gets a brand-new limiter with a full burst
+	// rather than being rate limited by its old, now-discarded bucket.
+	if !limiter.Allow(keys[0]) {
+		t.Fatalf("expected evicted key %q to get a fresh burst", keys[0])
+	}
+}
+
+func TestRateLimiter_TenantOverride_PrefixMatch(t *testing.T) {
+	cfg := config.RateLimitConfig{
+		Enabled:           true,
+		RequestsPerSecond: 10,
+		Burst:             10,
+		Tenants: map[string]config.TenantRateLimitConfig{
+			"abuse-": {RequestsPerSecond: 1, Burst: 1},
+		},
+	}
+	limiter := NewRateLimiter(cfg)
+	defer limiter.Close()
+
+	if !limiter.Allow("abuse-installation-1") {
+		t.Fatal("expected first request to be allowed")
+	}
+	if limiter.Allow("abuse-installation-1") {
+		t.Fatal("expected second request to be rate limited by the prefix override's burst of 1")
+	}
+
+	// A key that isn't prefixed by any tenant entry keeps the default limit.
+	if !limiter.Allow("regular-installation") {
+		t.Fatal("expected default-limit key to be allowed")
+	}
+	if !limiter.Allow("regular-installation") {
+		t.Fatal("expected default-limit key's larger burst to allow a second request")
+	}
+}
+
+func TestRateLimiter_TenantOverride_ExactKeyWinsOverPrefix(t *testing.T) {
+	cfg := config.RateLimitConfig{
+		Enabled:           true,
+		RequestsPerSecond: 10,
+		Burst:             10,
+		Tenants: map[string]config.TenantRateLimitConfig{
+			"abuse-":        {RequestsPerSecond: 1, Burst: 1},
+			"abuse-trusted": {RequestsPerSecond: 10, Burst: 10},
+		},
+	}
+	limiter := NewRateLimiter(cfg)
+	defer limiter.Close()
+
+	if !limiter.Allow("abuse-trusted") {
+		t.Fatal("expected first request to be allowed")
+	}
+	if !limiter.Allow("abuse-trusted") {
+		t.Fatal("expected the exact-match override's larger burst to win over the shorter prefix")
+	}
+}
+
+func TestRateLimiter_Penalty_EscalatesAfterThreshold(t *testing.T) {
+	cfg := config.RateLimitConfig{
+		Enabled:           true,
+		RequestsPerSecond: 1000,
+		Burst:             1,
+		Penalty: config.PenaltyConfig{
+			Enabled:     true,
+			Threshold:   2,
+			BaseSeconds: 1,
+			MaxSeconds:  300,
+		},
+	}
+	limiter := NewRateLimiter(cfg)
+	defer limiter.Close()
+
+	key := "repeat-offender"
+	if !limiter.Allow(key) {
+		t.Fatal("expected first request to be allowed")
+	}
+
+	// Exhaust the burst, then rack up rejections past Threshold.
+	for i := 0; i < 3; i++ {
+		if limiter.Allow(key) {
+			t.Fatalf("expected rejection %d to be rate limited", i)
+		}
+	}
+
+	// The 1s cool-down (BaseSeconds*2^0) started at the second rejection, so
+	// what is left of it is just under 1s, far above the ~1ms GCRA wait.
+	_, retryAfter, _ := limiter.AllowWithHint(key)
+	if retryAfter < 900*time.Millisecond {
+		t.Fatalf("expected the penalty cool-down to exceed the plain GCRA wait, got %s", retryAfter)
+	}
+}
+
 func TestRateLimitHTTP_NoLimiter(t *testing.T) {
 	handler := RateLimitHTTP(nil, func(r *http.Request) string { return "key" })(
 		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -233,7 +524,7 @@ func TestInstallationIDFromRequest_ValidPacket(t *testing.T) {
 		},
 	}
 
-	id := installationIDFromRequest(packet)
+	id := installationIDFromRequest(context.Background(), packet)
 	if id == "" {
 		t.Fatal("expected non-empty installation ID")
 	}
@@ -244,14 +535,14 @@ func TestInstallationIDFromRequest_ValidPacket(t *testing.T) {
 
 func TestInstallationIDFromRequest_NoMetadata(t *testing.T) {
 	packet := &pb.TelemetryPacket{}
-	id := installationIDFromRequest(packet)
+	id := installationIDFromRequest(context.Background(), packet)
 	if id != "" {
 		t.Fatalf("expected empty installation ID, got '%s'", id)
 	}
 }
 
 func TestInstallationIDFromRequest_WrongType(t *testing.T) {
-	id := installationIDFromRequest("not a packet")
+	id := installationIDFromRequest(context.Background(), "not a packet")
 	if id != "" {
 		t.Fatalf("expected empty installation ID for wrong type, got '%s'", id)
 	}
@@ -589,7 +880,7 @@ func TestCorsMiddleware_Disabled(t *testing.T) {
 
 func TestCorsMiddleware_WildcardOrigin(t *testing.T) {
 	cfg := config.CORSConfig{
-		Enabled: true,
+		Enabled:        true,
 		AllowedOrigins: []string{"*"},
 	}
 
@@ -616,7 +907,7 @@ func TestCorsMiddleware_WildcardOrigin(t *testing.T) {
 
 func TestCorsMiddleware_SpecificOrigin(t *testing.T) {
 	cfg := config.CORSConfig{
-		Enabled: true,
+		Enabled:        true,
 		AllowedOrigins: []string{"http://localhost:3000", "http://example.com"},
 	}
 
@@ -627,10 +918,10 @@ func TestCorsMiddleware_SpecificOrigin(t *testing.T) {
 	corsHandler := CorsMiddleware(handler, cfg)
 
 	tests := []struct {
-		name           string
-		origin         string
-		expectOrigin   string
-		expectCORS     bool
+		name         string
+		origin       string
+		expectOrigin string
+		expectCORS   bool
 	}{
 		{"allowed origin 1", "http://localhost:3000", "http://localhost:3000", true},
 		{"allowed origin 2", "http://example.com", "http://example.com", true},
@@ -668,7 +959,7 @@ func TestCorsMiddleware_SpecificOrigin(t *testing.T) {
 
 func TestCorsMiddleware_DefaultMethodsAndHeaders(t *testing.T) {
 	cfg := config.CORSConfig{
-		Enabled: true,
+		Enabled:        true,
 		AllowedOrigins: []string{"*"},
 		// Don't set methods to test defaults
 		// Headers are not set - should not be present in response
@@ -680,7 +971,10 @@ func TestCorsMiddleware_DefaultMethodsAndHeaders(t *testing.T) {
 
 	corsHandler := CorsMiddleware(handler, cfg)
 
-	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	// Access-Control-Allow-Methods/-Headers are only meaningful on a
+	// preflight response, so this exercises an OPTIONS request that doesn't
+	// name a specific method/headers to fall back to the configured sets.
+	req := httptest.NewRequest(http.MethodOptions, "/test", nil)
 	req.Header.Set("Origin", "http://example.com")
 	w := httptest.NewRecorder()
 
@@ -708,7 +1002,7 @@ func TestCorsMiddleware_DefaultMethodsAndHeaders(t *testing.T) {
 
 func TestCorsMiddleware_CustomMethodsAndHeaders(t *testing.T) {
 	cfg := config.CORSConfig{
-		Enabled: true,
+		Enabled:        true,
 		AllowedOrigins: []string{"*"},
 		AllowedMethods: []string{"GET", "POST"},
 		AllowedHeaders: []string{"Content-Type", "X-Custom-Header"},
@@ -720,7 +1014,7 @@ func TestCorsMiddleware_CustomMethodsAndHeaders(t *testing.T) {
 
 	corsHandler := CorsMiddleware(handler, cfg)
 
-	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req := httptest.NewRequest(http.MethodOptions, "/test", nil)
 	req.Header.Set("Origin", "http://example.com")
 	w := httptest.NewRecorder()
 
@@ -739,7 +1033,7 @@ func TestCorsMiddleware_CustomMethodsAndHeaders(t *testing.T) {
 
 func TestCorsMiddleware_OptionsRequest(t *testing.T) {
 	cfg := config.CORSConfig{
-		Enabled: true,
+		Enabled:        true,
 		AllowedOrigins: []string{"*"},
 	}
 
@@ -756,8 +1050,8 @@ func TestCorsMiddleware_OptionsRequest(t *testing.T) {
 
 	corsHandler.ServeHTTP(w, req)
 
-	if w.Code != http.StatusOK {
-		t.Fatalf("expected 200 for OPTIONS, got %d", w.Code)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 for OPTIONS, got %d", w.Code)
 	}
 
 	if w.Header().Get("Access-Control-Allow-Origin") == "" {
@@ -767,7 +1061,7 @@ func TestCorsMiddleware_OptionsRequest(t *testing.T) {
 
 func TestCorsMiddleware_PrivateNetworkAccess_Preflight(t *testing.T) {
 	cfg := config.CORSConfig{
-		Enabled: true,
+		Enabled:        true,
 		AllowedOrigins: []string{"*"},
 	}
 
@@ -785,8 +1079,8 @@ func TestCorsMiddleware_PrivateNetworkAccess_Preflight(t *testing.T) {
 
 	corsHandler.ServeHTTP(w, req)
 
-	if w.Code != http.StatusOK {
-		t.Fatalf("expected 200 for OPTIONS, got %d", w.Code)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("expected 204 for OPTIONS, got %d", w.Code)
 	}
 
 	if w.Header().Get("Access-Control-Allow-Private-Network") != "true" {
@@ -797,7 +1091,7 @@ func TestCorsMiddleware_PrivateNetworkAccess_Preflight(t *testing.T) {
 
 func TestCorsMiddleware_PrivateNetworkAccess_ActualRequest(t *testing.T) {
 	cfg := config.CORSConfig{
-		Enabled: true,
+		Enabled:        true,
 		AllowedOrigins: []string{"*"},
 	}
 
@@ -826,7 +1120,7 @@ func TestCorsMiddleware_PrivateNetworkAccess_ActualRequest(t *testing.T) {
 
 func TestCorsMiddleware_NoPrivateNetworkHeader(t *testing.T) {
 	cfg := config.CORSConfig{
-		Enabled: true,
+		Enabled:        true,
 		AllowedOrigins: []string{"*"},
 	}
 
@@ -851,3 +1145,315 @@ func TestCorsMiddleware_NoPrivateNetworkHeader(t *testing.T) {
 		t.Fatal("expected no Access-Control-Allow-Private-Network header when not requested")
 	}
 }
+
+func TestCorsMiddleware_GlobOrigin(t *testing.T) {
+	cfg := config.CORSConfig{
+		Enabled:        true,
+		AllowedOrigins: []string{"https://*.threatfabric.com"},
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	corsHandler := CorsMiddleware(handler, cfg)
+
+	tests := []struct {
+		name       string
+		origin     string
+		expectCORS bool
+	}{
+		{"matching subdomain", "https://app.threatfabric.com", true},
+		{"matching nested subdomain", "https://a.b.threatfabric.com", true},
+		{"non-matching host", "https://evil.com", false},
+		{"non-matching scheme", "http://app.threatfabric.com", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/test", nil)
+			req.Header.Set("Origin", tt.origin)
+			w := httptest.NewRecorder()
+
+			corsHandler.ServeHTTP(w, req)
+
+			got := w.Header().Get("Access-Control-Allow-Origin")
+			if tt.expectCORS && got != tt.origin {
+				t.Fatalf("expected origin %s, got %s", tt.origin, got)
+			}
+			if !tt.expectCORS && got != "" {
+				t.Fatalf("expected no CORS headers for %s, got %s", tt.origin, got)
+			}
+		})
+	}
+}
+
+func TestCorsMiddleware_AllowCredentials_EchoesOrigin(t *testing.T) {
+	cfg := config.CORSConfig{
+		Enabled:          true,
+		AllowedOrigins:   []string{"*"},
+		AllowCredentials: true,
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	corsHandler := CorsMiddleware(handler, cfg)
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("Origin", "http://example.com")
+	w := httptest.NewRecorder()
+
+	corsHandler.ServeHTTP(w, req)
+
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "http://example.com" {
+		t.Fatalf("expected echoed origin, got %s", got)
+	}
+	if got := w.Header().Get("Access-Control-Allow-Credentials"); got != "true" {
+		t.Fatalf("expected Access-Control-Allow-Credentials: true, got %s", got)
+	}
+}
+
+func TestCorsMiddleware_MaxAgeAndExposedHeaders(t *testing.T) {
+	cfg := config.CORSConfig{
+		Enabled:        true,
+		AllowedOrigins: []string{"*"},
+		ExposedHeaders: []string{"X-Request-Id"},
+		MaxAge:         10 * time.Minute,
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	corsHandler := CorsMiddleware(handler, cfg)
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("Origin", "http://example.com")
+	w := httptest.NewRecorder()
+	corsHandler.ServeHTTP(w, req)
+
+	if got := w.Header().Get("Access-Control-Expose-Headers"); got != "X-Request-Id" {
+		t.Fatalf("expected exposed headers, got %s", got)
+	}
+
+	preflight := httptest.NewRequest(http.MethodOptions, "/test", nil)
+	preflight.Header.Set("Origin", "http://example.com")
+	preflight.Header.Set("Access-Control-Request-Method", "GET")
+	pw := httptest.NewRecorder()
+	corsHandler.ServeHTTP(pw, preflight)
+
+	if got := pw.Header().Get("Access-Control-Max-Age"); got != "600" {
+		t.Fatalf("expected Access-Control-Max-Age: 600, got %s", got)
+	}
+}
+
+func TestCorsMiddleware_PreflightRejectsDisallowedMethod(t *testing.T) {
+	cfg := config.CORSConfig{
+		Enabled:        true,
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{"GET"},
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("handler should not be called for a rejected preflight")
+	})
+
+	corsHandler := CorsMiddleware(handler, cfg)
+
+	req := httptest.NewRequest(http.MethodOptions, "/test", nil)
+	req.Header.Set("Origin", "http://example.com")
+	req.Header.Set("Access-Control-Request-Method", "DELETE")
+	w := httptest.NewRecorder()
+
+	corsHandler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for a disallowed preflight method, got %d", w.Code)
+	}
+}
+
+func TestCorsMiddleware_PreflightRejectsDisallowedHeader(t *testing.T) {
+	cfg := config.CORSConfig{
+		Enabled:        true,
+		AllowedOrigins: []string{"*"},
+		AllowedHeaders: []string{"Content-Type"},
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("handler should not be called for a rejected preflight")
+	})
+
+	corsHandler := CorsMiddleware(handler, cfg)
+
+	req := httptest.NewRequest(http.MethodOptions, "/test", nil)
+	req.Header.Set("Origin", "http://example.com")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	req.Header.Set("Access-Control-Request-Headers", "X-Not-Allowed")
+	w := httptest.NewRecorder()
+
+	corsHandler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 for a disallowed preflight header, got %d", w.Code)
+	}
+}
+
+func TestCorsMiddleware_PerRouteOverride(t *testing.T) {
+	base, err := NewCORSPolicy(config.CORSConfig{
+		Enabled:        true,
+		AllowedOrigins: []string{"http://example.com"},
+	})
+	if err != nil {
+		t.Fatalf("NewCORSPolicy: %v", err)
+	}
+
+	routePolicy, err := base.WithOverride(config.CORSConfig{
+		AllowedOrigins: []string{"http://admin.example.com"},
+	})
+	if err != nil {
+		t.Fatalf("WithOverride: %v", err)
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	baseHandler := base.Middleware(handler)
+	routeHandler := routePolicy.Middleware(handler)
+
+	req := httptest.NewRequest(http.MethodGet, "/admin", nil)
+	req.Header.Set("Origin", "http://admin.example.com")
+
+	w := httptest.NewRecorder()
+	baseHandler.ServeHTTP(w, req)
+	if got := w.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Fatalf("expected base policy to reject admin origin, got %s", got)
+	}
+
+	w2 := httptest.NewRecorder()
+	routeHandler.ServeHTTP(w2, req)
+	if got := w2.Header().Get("Access-Control-Allow-Origin"); got != "http://admin.example.com" {
+		t.Fatalf("expected route override to allow admin origin, got %s", got)
+	}
+}
+
+func TestCorsMiddleware_VaryOrigin(t *testing.T) {
+	cfg := config.CORSConfig{
+		Enabled:        true,
+		AllowedOrigins: []string{"http://example.com"},
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	corsHandler := CorsMiddleware(handler, cfg)
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.Header.Set("Origin", "http://example.com")
+	w := httptest.NewRecorder()
+
+	corsHandler.ServeHTTP(w, req)
+
+	if got := w.Header().Values("Vary"); !containsValue(got, "Origin") {
+		t.Fatalf("expected Vary: Origin, got %v", got)
+	}
+}
+
+func TestCorsMiddleware_VaryAccessControlRequestHeadersOnPreflight(t *testing.T) {
+	cfg := config.CORSConfig{
+		Enabled:        true,
+		AllowedOrigins: []string{"*"},
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	corsHandler := CorsMiddleware(handler, cfg)
+
+	preflight := httptest.NewRequest(http.MethodOptions, "/test", nil)
+	preflight.Header.Set("Origin", "http://example.com")
+	preflight.Header.Set("Access-Control-Request-Method", "GET")
+	pw := httptest.NewRecorder()
+	corsHandler.ServeHTTP(pw, preflight)
+
+	vary := pw.Header().Values("Vary")
+	if !containsValue(vary, "Origin") {
+		t.Fatalf("expected Vary: Origin on preflight, got %v", vary)
+	}
+	if !containsValue(vary, "Access-Control-Request-Headers") {
+		t.Fatalf("expected Vary: Access-Control-Request-Headers on preflight, got %v", vary)
+	}
+
+	actual := httptest.NewRequest(http.MethodGet, "/test", nil)
+	actual.Header.Set("Origin", "http://example.com")
+	aw := httptest.NewRecorder()
+	corsHandler.ServeHTTP(aw, actual)
+
+	if containsValue(aw.Header().Values("Vary"), "Access-Control-Request-Headers") {
+		t.Fatalf("expected no Vary: Access-Control-Request-Headers on a non-preflight response, got %v", aw.Header().Values("Vary"))
+	}
+}
+
+func TestCorsMiddleware_PreflightEchoesOnlyRequestedMethod(t *testing.T) {
+	cfg := config.CORSConfig{
+		Enabled:        true,
+		AllowedOrigins: []string{"*"},
+		AllowedMethods: []string{"GET", "POST", "PUT", "DELETE"},
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	corsHandler := CorsMiddleware(handler, cfg)
+
+	req := httptest.NewRequest(http.MethodOptions, "/test", nil)
+	req.Header.Set("Origin", "http://example.com")
+	req.Header.Set("Access-Control-Request-Method", "POST")
+	w := httptest.NewRecorder()
+
+	corsHandler.ServeHTTP(w, req)
+
+	if got := w.Header().Get("Access-Control-Allow-Methods"); got != "POST" {
+		t.Fatalf("expected only the requested method 'POST' to be echoed, got %s", got)
+	}
+}
+
+func TestCorsMiddleware_PreflightEchoesOnlyRequestedHeaders(t *testing.T) {
+	cfg := config.CORSConfig{
+		Enabled:        true,
+		AllowedOrigins: []string{"*"},
+		AllowedHeaders: []string{"Content-Type", "X-Custom-Header", "Authorization"},
+	}
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	corsHandler := CorsMiddleware(handler, cfg)
+
+	req := httptest.NewRequest(http.MethodOptions, "/test", nil)
+	req.Header.Set("Origin", "http://example.com")
+	req.Header.Set("Access-Control-Request-Method", "GET")
+	req.Header.Set("Access-Control-Request-Headers", "X-Custom-Header, Content-Type")
+	w := httptest.NewRecorder()
+
+	corsHandler.ServeHTTP(w, req)
+
+	if got := w.Header().Get("Access-Control-Allow-Headers"); got != "X-Custom-Header, Content-Type" {
+		t.Fatalf("expected only the requested headers to be echoed, got %s", got)
+	}
+}
+
+func containsValue(values []string, want string) bool {
+	for _, v := range values {
+		if v == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,154 @@
+package middleware
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/threatfabric-devops/tf-telemetry/internal/config"
+)
+
+// ClientCATLSConfig builds a server-side tls.Config that requires and
+// verifies client certificates against cfg's CA bundle. Callers set it on
+// http.Server.TLSConfig or wrap it with credentials.NewTLS for gRPC.
+func ClientCATLSConfig(cfg config.MTLSConfig) (*tls.Config, error) {
+	caPEM, err := os.ReadFile(cfg.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("read client CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates found in %s", cfg.ClientCAFile)
+	}
+	return &tls.Config{
+		ClientCAs:  pool,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+	}, nil
+}
+
+// MTLSCredentials wraps tlsCfg as gRPC server transport credentials.
+func MTLSCredentials(tlsCfg *tls.Config) credentials.TransportCredentials {
+	return credentials.NewTLS(tlsCfg)
+}
+
+// MTLSAttempt checks the client certificate the TLS handshake already
+// verified against cfg's CN/SAN allow-list.
+func MTLSAttempt(cfg config.MTLSConfig) AuthAttempt {
+	return func(r *http.Request) (string, bool) {
+		if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+			return "", false
+		}
+		return matchAllowedCert(r.TLS.PeerCertificates[0], cfg)
+	}
+}
+
+// MTLSAttemptGRPC is the gRPC analogue of MTLSAttempt.
+func MTLSAttemptGRPC(cfg config.MTLSConfig) GRPCAuthAttempt {
+	return func(ctx context.Context) (string, bool) {
+		cert, ok := peerCertFromContext(ctx)
+		if !ok {
+			return "", false
+		}
+		return matchAllowedCert(cert, cfg)
+	}
+}
+
+// MTLSHTTP wraps an HTTP handler so only a request bearing a client
+// certificate that satisfies cfg's CA/CN/SAN requirements is let through,
+// attaching the resolved CN/SPIFFE-ID SAN to the request context.
+func MTLSHTTP(cfg config.MTLSConfig) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			principal, ok := matchAllowedCert(r.TLS.PeerCertificates[0], cfg)
+			if !ok {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(ContextWithPrincipal(r.Context(), principal)))
+		})
+	}
+}
+
+// MTLSUnary is the gRPC analogue of MTLSHTTP.
+func MTLSUnary(cfg config.MTLSConfig) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		cert, ok := peerCertFromContext(ctx)
+		if !ok {
+			return nil, status.Error(codes.Unauthenticated, "missing client certificate")
+		}
+		principal, ok := matchAllowedCert(cert, cfg)
+		if !ok {
+			return nil, status.Error(codes.Unauthenticated, "client certificate rejected")
+		}
+		return handler(ContextWithPrincipal(ctx, principal), req)
+	}
+}
+
+// peerCertFromContext extracts the verified leaf client certificate the TLS
+// handshake placed on ctx via gRPC's peer/credentials machinery.
+func peerCertFromContext(ctx context.Context) (*x509.Certificate, bool) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.PeerCertificates) == 0 {
+		return nil, false
+	}
+	return tlsInfo.State.PeerCertificates[0], true
+}
+
+// matchAllowedCert resolves cert's principal - its CN, or a SPIFFE-ID URI
+// SAN when present - and checks it against cfg's allow-lists. RequireSAN
+// rejects certificates with no DNS or URI SAN at all; an empty
+// AllowedCommonNames/AllowedSANs pair accepts any CA-verified certificate
+// that clears RequireSAN.
+func matchAllowedCert(cert *x509.Certificate, cfg config.MTLSConfig) (string, bool) {
+	hasSAN := len(cert.DNSNames) > 0 || len(cert.URIs) > 0
+	if cfg.RequireSAN && !hasSAN {
+		return "", false
+	}
+
+	principal := cert.Subject.CommonName
+	if len(cert.URIs) > 0 {
+		principal = cert.URIs[0].String()
+	}
+
+	if len(cfg.AllowedCommonNames) == 0 && len(cfg.AllowedSANs) == 0 {
+		return principal, true
+	}
+
+	for _, allowed := range cfg.AllowedCommonNames {
+		if cert.Subject.CommonName == allowed {
+			return principal, true
+		}
+	}
+	for _, san := range cert.DNSNames {
+		for _, allowed := range cfg.AllowedSANs {
+			if san == allowed {
+				return san, true
+			}
+		}
+	}
+	for _, uri := range cert.URIs {
+		for _, allowed := range cfg.AllowedSANs {
+			if uri.String() == allowed {
+				return uri.String(), true
+			}
+		}
+	}
+	return "", false
+}
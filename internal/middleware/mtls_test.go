@@ -0,0 +1,245 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/threatfabric-devops/tf-telemetry/internal/config"
+)
+
+// newTestCA creates a self-signed CA certificate usable as a CA pool root.
+func newTestCA(t *testing.T) (*x509.Certificate, *rsa.PrivateKey) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate CA key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create CA cert: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse CA cert: %v", err)
+	}
+	return cert, key
+}
+
+// newTestLeaf signs a client-auth leaf certificate under ca/caKey, with the
+// given validity window, so callers can construct both well-formed and
+// expired certificates.
+func newTestLeaf(t *testing.T, ca *x509.Certificate, caKey *rsa.PrivateKey, commonName string, dnsNames []string, notBefore, notAfter time.Time) *x509.Certificate {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate leaf key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("create leaf cert: %v", err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("parse leaf cert: %v", err)
+	}
+	return leaf
+}
+
+func TestMTLSHTTP_MissingCert(t *testing.T) {
+	handler := MTLSHTTP(config.MTLSConfig{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a client cert, got %d", rr.Code)
+	}
+}
+
+func TestMTLSHTTP_AllowedCert(t *testing.T) {
+	ca, caKey := newTestCA(t)
+	leaf := newTestLeaf(t, ca, caKey, "device-1", []string{"device-1"}, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+
+	cfg := config.MTLSConfig{AllowedSANs: []string{"device-1"}}
+	handler := MTLSHTTP(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := PrincipalFromContext(r.Context())
+		if !ok || principal != "device-1" {
+			t.Errorf("expected principal 'device-1' in context, got %q (ok=%v)", principal, ok)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200 with an allow-listed cert, got %d", rr.Code)
+	}
+}
+
+func TestMTLSHTTP_CommonNameNotAllowed(t *testing.T) {
+	ca, caKey := newTestCA(t)
+	leaf := newTestLeaf(t, ca, caKey, "device-2", nil, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+
+	cfg := config.MTLSConfig{AllowedCommonNames: []string{"device-1"}}
+	handler := MTLSHTTP(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a CN not on the allow-list, got %d", rr.Code)
+	}
+}
+
+func TestMTLSHTTP_RequireSANRejectsCommonNameOnlyCert(t *testing.T) {
+	ca, caKey := newTestCA(t)
+	leaf := newTestLeaf(t, ca, caKey, "device-1", nil, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+
+	cfg := config.MTLSConfig{RequireSAN: true}
+	handler := MTLSHTTP(cfg)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf}}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 for a CN-only cert when RequireSAN is set, got %d", rr.Code)
+	}
+}
+
+func grpcContextWithCert(cert *x509.Certificate) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{
+		AuthInfo: credentials.TLSInfo{
+			State: tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}},
+		},
+	})
+}
+
+func TestMTLSUnary_MissingCert(t *testing.T) {
+	interceptor := MTLSUnary(config.MTLSConfig{})
+	handler := func(ctx context.Context, req any) (any, error) { return "ok", nil }
+
+	_, err := interceptor(context.Background(), "req", &grpc.UnaryServerInfo{}, handler)
+	if err == nil {
+		t.Fatal("expected an error without a client cert")
+	}
+	if st, ok := status.FromError(err); !ok || st.Code() != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated, got %v", err)
+	}
+}
+
+func TestMTLSUnary_AllowedCert(t *testing.T) {
+	ca, caKey := newTestCA(t)
+	leaf := newTestLeaf(t, ca, caKey, "device-1", []string{"device-1"}, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+
+	interceptor := MTLSUnary(config.MTLSConfig{AllowedSANs: []string{"device-1"}})
+	var gotPrincipal string
+	handler := func(ctx context.Context, req any) (any, error) {
+		principal, _ := PrincipalFromContext(ctx)
+		gotPrincipal = principal
+		return "ok", nil
+	}
+
+	_, err := interceptor(grpcContextWithCert(leaf), "req", &grpc.UnaryServerInfo{}, handler)
+	if err != nil {
+		t.Fatalf("expected success with an allow-listed cert, got %v", err)
+	}
+	if gotPrincipal != "device-1" {
+		t.Fatalf("expected principal 'device-1', got %q", gotPrincipal)
+	}
+}
+
+func TestMTLSUnary_CommonNameNotAllowed(t *testing.T) {
+	ca, caKey := newTestCA(t)
+	leaf := newTestLeaf(t, ca, caKey, "device-2", nil, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+
+	interceptor := MTLSUnary(config.MTLSConfig{AllowedCommonNames: []string{"device-1"}})
+	handler := func(ctx context.Context, req any) (any, error) { return "ok", nil }
+
+	_, err := interceptor(grpcContextWithCert(leaf), "req", &grpc.UnaryServerInfo{}, handler)
+	if err == nil {
+		t.Fatal("expected an error for a CN not on the allow-list")
+	}
+	if st, ok := status.FromError(err); !ok || st.Code() != codes.Unauthenticated {
+		t.Fatalf("expected Unauthenticated, got %v", err)
+	}
+}
+
+func TestClientCATLSConfig_RejectsCertFromWrongCA(t *testing.T) {
+	trustedCA, _ := newTestCA(t)
+	otherCA, otherKey := newTestCA(t)
+	leafFromOtherCA := newTestLeaf(t, otherCA, otherKey, "device-1", nil, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+
+	pool := x509.NewCertPool()
+	pool.AddCert(trustedCA)
+
+	_, err := leafFromOtherCA.Verify(x509.VerifyOptions{
+		Roots:     pool,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err == nil {
+		t.Fatal("expected verification to fail for a certificate signed by an untrusted CA")
+	}
+}
+
+func TestClientCATLSConfig_RejectsExpiredCert(t *testing.T) {
+	ca, caKey := newTestCA(t)
+	expired := newTestLeaf(t, ca, caKey, "device-1", nil, time.Now().Add(-2*time.Hour), time.Now().Add(-time.Hour))
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	_, err := expired.Verify(x509.VerifyOptions{
+		Roots:     pool,
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+	if err == nil {
+		t.Fatal("expected verification to fail for an expired certificate")
+	}
+}
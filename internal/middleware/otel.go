@@ -0,0 +1,190 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/threatfabric-devops/tf-telemetry/internal/config"
+	"github.com/threatfabric-devops/tf-telemetry/internal/gen/pb"
+	"github.com/threatfabric-devops/tf-telemetry/internal/metrics"
+)
+
+const tracerName = "github.com/threatfabric-devops/tf-telemetry/internal/middleware"
+
+// NewTracerProvider builds a trace provider for cfg.Exporter ("stdout",
+// "otlp/grpc", "otlp/http") and installs it as the global provider via
+// otel.SetTracerProvider, so TracingUnary/TracingHTTP (which look up their
+// tracer from the global provider) start exporting immediately. Callers
+// should defer the returned shutdown func to flush pending spans on exit.
+// cfg.Enabled() == false returns a no-op shutdown and leaves the global
+// provider untouched.
+func NewTracerProvider(ctx context.Context, cfg config.TracingConfig) (shutdown func(context.Context) error, err error) {
+	noop := func(context.Context) error { return nil }
+	if !cfg.Enabled() {
+		return noop, nil
+	}
+
+	exporter, err := newSpanExporter(ctx, cfg)
+	if err != nil {
+		return noop, err
+	}
+
+	attrs := []attribute.KeyValue{semconv.ServiceName("tf-telemetry")}
+	for k, v := range cfg.ResourceAttributes {
+		attrs = append(attrs, attribute.String(k, v))
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attrs...))
+	if err != nil {
+		return noop, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return tp.Shutdown, nil
+}
+
+func newSpanExporter(ctx context.Context, cfg config.TracingConfig) (sdktrace.SpanExporter, error) {
+	switch cfg.Exporter {
+	case "stdout":
+		return stdouttrace.New(stdouttrace.WithPrettyPrint())
+	case "otlp/grpc":
+		opts := []otlptracegrpc.Option{otlptracegrpc.WithInsecure()}
+		if cfg.OTLPEndpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpoint(cfg.OTLPEndpoint))
+		}
+		return otlptracegrpc.New(ctx, opts...)
+	case "otlp/http":
+		opts := []otlptracehttp.Option{}
+		if cfg.OTLPEndpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpoint(cfg.OTLPEndpoint))
+		}
+		return otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, fmt.Errorf("unsupported tracing exporter %q", cfg.Exporter)
+	}
+}
+
+// TracingHTTP wraps next with otelhttp's instrumentation, which extracts an
+// incoming traceparent header, starts a server span for the request, and
+// passes the resulting context through to next - so the span reaches
+// TelemetrySender.SendTelemetry via r.Context() and its Elasticsearch
+// bulk-indexing spans nest underneath it.
+func TracingHTTP() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return otelhttp.NewHandler(next, "tf-telemetry-http")
+	}
+}
+
+// grpcMetadataCarrier adapts incoming gRPC metadata to
+// propagation.TextMapCarrier so otel's configured propagator can extract a
+// traceparent from it.
+type grpcMetadataCarrier metadata.MD
+
+func (c grpcMetadataCarrier) Get(key string) string {
+	values := metadata.MD(c).Get(key)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+func (c grpcMetadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c grpcMetadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// TracingUnary returns a gRPC unary interceptor that extracts an incoming
+// traceparent from request metadata, starts a span for the RPC, and passes
+// the resulting context through to handler so TelemetrySender.SendTelemetry's
+// downstream Elasticsearch bulk-indexing spans nest under it.
+func TracingUnary() grpc.UnaryServerInterceptor {
+	tracer := otel.Tracer(tracerName)
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		md, ok := metadata.FromIncomingContext(ctx)
+		if !ok {
+			md = metadata.MD{}
+		}
+		ctx = otel.GetTextMapPropagator().Extract(ctx, grpcMetadataCarrier(md))
+
+		ctx, span := tracer.Start(ctx, info.FullMethod, trace.WithSpanKind(trace.SpanKindServer))
+		defer span.End()
+
+		resp, err := handler(ctx, req)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(otelcodes.Error, status.Convert(err).Message())
+		} else {
+			span.SetStatus(otelcodes.Ok, "")
+		}
+		return resp, err
+	}
+}
+
+// MetricsUnary returns a gRPC unary interceptor recording SendTelemetry
+// request count, latency, and payload size to Prometheus (see the
+// RequestsTotal/RequestDurationSeconds/RequestPayloadSizeBytes collectors in
+// internal/metrics), each labelled by the telemetry packet's platform and
+// SDK version plus the gRPC status code the call returned. This is
+// independent of TracingUnary's OTel spans, so /metrics keeps working for
+// operators who scrape Prometheus instead of running an OTLP collector.
+func MetricsUnary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		duration := time.Since(start).Seconds()
+
+		platform, sdkVersion, size := telemetryRequestLabels(req)
+		code := status.Code(err).String()
+
+		metrics.RequestsTotal.WithLabelValues(platform, sdkVersion, code).Inc()
+		metrics.RequestDurationSeconds.WithLabelValues(platform, sdkVersion, code).Observe(duration)
+		if size > 0 {
+			metrics.RequestPayloadSizeBytes.WithLabelValues(platform, sdkVersion).Observe(float64(size))
+		}
+		return resp, err
+	}
+}
+
+// telemetryRequestLabels extracts the platform/sdk_version labels and wire
+// size MetricsUnary records, or ("unknown", "unknown", 0) for a request that
+// isn't a *pb.TelemetryPacket (e.g. an OTLP passthrough call).
+func telemetryRequestLabels(req any) (platform, sdkVersion string, size int) {
+	packet, ok := req.(*pb.TelemetryPacket)
+	if !ok || packet.GetMetadata() == nil {
+		return "unknown", "unknown", 0
+	}
+	meta := packet.GetMetadata()
+	return meta.GetPlatform().String(), strconv.FormatInt(meta.GetSdkVersionPacked(), 10), proto.Size(packet)
+}
@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/threatfabric-devops/tf-telemetry/internal/config"
+	"github.com/threatfabric-devops/tf-telemetry/internal/gen/pb"
+)
+
+func TestTracingConfig_Enabled(t *testing.T) {
+	cases := []struct {
+		name string
+		cfg  config.TracingConfig
+		want bool
+	}{
+		{"zero value", config.TracingConfig{}, false},
+		{"explicit none", config.TracingConfig{Exporter: "none"}, false},
+		{"stdout", config.TracingConfig{Exporter: "stdout"}, true},
+		{"otlp grpc", config.TracingConfig{Exporter: "otlp/grpc"}, true},
+		{"otlp http", config.TracingConfig{Exporter: "otlp/http"}, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.cfg.Enabled(); got != tc.want {
+				t.Fatalf("Enabled() = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNewTracerProvider_DisabledIsNoop(t *testing.T) {
+	shutdown, err := NewTracerProvider(context.Background(), config.TracingConfig{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("expected no-op shutdown to succeed, got %v", err)
+	}
+}
+
+func TestNewTracerProvider_UnsupportedExporter(t *testing.T) {
+	_, err := NewTracerProvider(context.Background(), config.TracingConfig{Exporter: "carrier-pigeon"})
+	if err == nil {
+		t.Fatal("expected an error for an unsupported exporter")
+	}
+}
+
+func TestTracingUnary_PassesContextAndResult(t *testing.T) {
+	interceptor := TracingUnary()
+	called := false
+	handler := func(ctx context.Context, req any) (any, error) {
+		called = true
+		return "ok", nil
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/pb.Collector/SendTelemetry"}
+
+	resp, err := interceptor(context.Background(), &pb.TelemetryPacket{}, info, handler)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !called {
+		t.Fatal("expected handler to be invoked")
+	}
+	if resp != "ok" {
+		t.Fatalf("expected handler's response to pass through, got %v", resp)
+	}
+}
+
+func TestTracingUnary_PropagatesHandlerError(t *testing.T) {
+	interceptor := TracingUnary()
+	wantErr := status.Error(codes.Unauthenticated, "no credentials")
+	handler := func(ctx context.Context, req any) (any, error) {
+		return nil, wantErr
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: "/pb.Collector/SendTelemetry"}
+
+	_, err := interceptor(context.Background(), &pb.TelemetryPacket{}, info, handler)
+	if status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("expected the handler's error to pass through, got %v", err)
+	}
+}
+
+func TestMetricsUnary_RecordsLabelsFromPacket(t *testing.T) {
+	interceptor := MetricsUnary()
+	handler := func(ctx context.Context, req any) (any, error) {
+		return &pb.Ack{Success: true}, nil
+	}
+	packet := &pb.TelemetryPacket{Metadata: &pb.ClientMetadata{Platform: pb.Platform_ANDROID, SdkVersionPacked: 10203}}
+	info := &grpc.UnaryServerInfo{FullMethod: "/pb.Collector/SendTelemetry"}
+
+	if _, err := interceptor(context.Background(), packet, info, handler); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestTelemetryRequestLabels_UnknownRequestType(t *testing.T) {
+	platform, sdkVersion, size := telemetryRequestLabels("not a packet")
+	if platform != "unknown" || sdkVersion != "unknown" || size != 0 {
+		t.Fatalf("expected unknown labels and zero size for a non-packet request, got (%q, %q, %d)", platform, sdkVersion, size)
+	}
+}
+
+func TestTelemetryRequestLabels_FromPacket(t *testing.T) {
+	packet := &pb.TelemetryPacket{Metadata: &pb.ClientMetadata{Platform: pb.Platform_IOS, SdkVersionPacked: 70605}}
+	platform, sdkVersion, size := telemetryRequestLabels(packet)
+	if platform != pb.Platform_IOS.String() {
+		t.Fatalf("expected platform %q, got %q", pb.Platform_IOS.String(), platform)
+	}
+	if sdkVersion != "70605" {
+		t.Fatalf("expected sdk_version %q, got %q", "70605", sdkVersion)
+	}
+	if size <= 0 {
+		t.Fatalf("expected a positive serialized size, got %d", size)
+	}
+}
+
+func TestGRPCMetadataCarrier_GetSetKeys(t *testing.T) {
+	carrier := grpcMetadataCarrier(metadata.MD{})
+	carrier.Set("traceparent", "00-trace-id-span-id-01")
+
+	if got := carrier.Get("traceparent"); got != "00-trace-id-span-id-01" {
+		t.Fatalf("expected Get to return the value Set stored, got %q", got)
+	}
+	if got := carrier.Get("missing"); got != "" {
+		t.Fatalf("expected Get of a missing key to return empty, got %q", got)
+	}
+
+	keys := carrier.Keys()
+	if len(keys) != 1 || keys[0] != "traceparent" {
+		t.Fatalf("expected Keys() to return [traceparent], got %v", keys)
+	}
+}
@@ -2,93 +2,540 @@
 package middleware
 
 import (
+	"container/list"
 	"context"
 	"encoding/hex"
+	"hash/fnv"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
-	"golang.org/x/time/rate"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 
 	"github.com/threatfabric-devops/tf-telemetry/internal/config"
 	"github.com/threatfabric-devops/tf-telemetry/internal/gen/pb"
+	"github.com/threatfabric-devops/tf-telemetry/internal/metrics"
 )
 
-// RateLimiter maintains per-key limiters backed by installation IDs.
-type RateLimiter struct {
-	limit    rate.Limit
+// retryPushbackTrailer is the gRPC convention for a server-computed backoff
+// hint (milliseconds until the client should retry), so SDKs that understand
+// it can back off intelligently instead of hammering the server.
+const retryPushbackTrailer = "grpc-retry-pushback-ms"
+
+// rateLimiterShardCount bounds lock contention: with thousands of
+// concurrently-active installation IDs, a single map mutex becomes a
+// bottleneck, so keys are hashed across this many independently-locked
+// shards instead.
+const rateLimiterShardCount = 32
+
+const defaultJanitorInterval = 30 * time.Second
+
+// defaultMaxKeys is the per-RateLimiter cap on tracked keys used when
+// RateLimitConfig.MaxKeys is unset.
+const defaultMaxKeys = 100_000
+
+// rateLimiterEntry is one key's GCRA (generic cell rate algorithm) state:
+// tat ("theoretical arrival time") is the only value the algorithm needs to
+// track per key, which is why this holds up far better than a token-bucket
+// goroutine-per-key under high key cardinality. rps/burst are resolved once
+// at creation (from a tenant override or the limiter's default) and are
+// additionally scaled by RateLimiter.fraction at check time when adaptive
+// mode is enabled. lastSeen lets the janitor identify idle keys, and key
+// records its own map key so the LRU list can evict a shard's tail without a
+// reverse lookup.
+type rateLimiterEntry struct {
+	key      string
+	rps      float64
 	burst    int
-	mu       sync.Mutex
-	limiters map[string]*rate.Limiter
+	tatNano  atomic.Int64
+	lastSeen atomic.Int64 // UnixNano
+
+	// consecutiveRejections and penaltyUntilNano track PenaltyConfig's
+	// escalating cool-down; both stay zero while penalty mode is disabled.
+	consecutiveRejections atomic.Int64
+	penaltyUntilNano      atomic.Int64
+}
+
+// penaltyWait reports whether e is still inside a cool-down set by a prior
+// applyPenalty call, and how much longer it has left.
+func (e *rateLimiterEntry) penaltyWait() (time.Duration, bool) {
+	until := e.penaltyUntilNano.Load()
+	if until == 0 {
+		return 0, false
+	}
+	remaining := time.Until(time.Unix(0, until))
+	if remaining <= 0 {
+		return 0, false
+	}
+	return remaining, true
+}
+
+// rateLimiterShard bounds one hash bucket's entries to maxKeys using an LRU
+// list: order.Front is the most recently touched entry, order.Back is the
+// least. Because every Allow call moves its entry to the front, the list is
+// always sorted by recency, so the janitor can evict idle entries by
+// trimming the tail instead of scanning the whole shard.
+type rateLimiterShard struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+	maxKeys int
+}
+
+// RateLimiter maintains per-key limiters backed by installation IDs. Entries
+// are sharded by a hash of the key to spread out lock contention; within each
+// shard, entries are capped at MaxKeys (split evenly across shards) using an
+// LRU list, and a background janitor evicts entries idle for longer than
+// IdleTTL to bound memory under client churn. When adaptive mode is enabled,
+// every per-key limit is additionally scaled by a shared fraction that the
+// janitor adjusts AIMD-style: multiplicative decrease while Inflight() is
+// above the configured high-watermark, additive recovery once it drops back
+// below. When penalty mode is enabled, a key that keeps getting rejected
+// accumulates an escalating cool-down on top of the plain GCRA wait (see
+// applyPenalty). The same RateLimiter is shared by httpserver and grpcserver
+// (via RateLimitHTTP/RateLimitUnary) so a quota is enforced identically
+// regardless of which transport a client uses.
+type RateLimiter struct {
+	limit   float64
+	burst   int
+	tenants map[string]config.TenantRateLimitConfig
+
+	shards [rateLimiterShardCount]*rateLimiterShard
+
+	idleTTL         time.Duration
+	janitorInterval time.Duration
+	closeCh         chan struct{}
+	closeOnce       sync.Once
+	wg              sync.WaitGroup
+
+	adaptive    config.AdaptiveRateLimitConfig
+	inflight    atomic.Int64
+	fractionMu  sync.Mutex
+	fraction    float64
+	activeCount atomic.Int64
+
+	penalty config.PenaltyConfig
 }
 
-// NewRateLimiter returns a limiter configured from rate limit configuration.
+// NewRateLimiter returns a limiter configured from rate limit configuration,
+// or nil if rate limiting is disabled. When enabled, a background janitor
+// goroutine starts immediately; callers must call Close to stop it.
 func NewRateLimiter(cfg config.RateLimitConfig) *RateLimiter {
 	if !cfg.Enabled || cfg.RequestsPerSecond <= 0 {
 		return nil
 	}
-	return &RateLimiter{
-		limit:    rate.Limit(cfg.RequestsPerSecond),
-		burst:    cfg.Burst,
-		limiters: make(map[string]*rate.Limiter),
+	maxKeys := cfg.MaxKeys
+	if maxKeys <= 0 {
+		maxKeys = defaultMaxKeys
+	}
+	maxKeysPerShard := maxKeys / rateLimiterShardCount
+	if maxKeysPerShard < 1 {
+		maxKeysPerShard = 1
+	}
+	r := &RateLimiter{
+		limit:           cfg.RequestsPerSecond,
+		burst:           cfg.Burst,
+		tenants:         cfg.Tenants,
+		idleTTL:         time.Duration(cfg.IdleTTLSeconds) * time.Second,
+		janitorInterval: defaultJanitorInterval,
+		closeCh:         make(chan struct{}),
+		adaptive:        cfg.Adaptive,
+		fraction:        1,
+		penalty:         cfg.Penalty,
+	}
+	for i := range r.shards {
+		r.shards[i] = &rateLimiterShard{
+			entries: make(map[string]*list.Element),
+			order:   list.New(),
+			maxKeys: maxKeysPerShard,
+		}
+	}
+	if r.idleTTL > 0 || r.adaptive.Enabled {
+		r.wg.Add(1)
+		go r.janitorLoop()
+	}
+	return r
+}
+
+// Close stops the background janitor. It is safe to call multiple times and
+// on a nil RateLimiter.
+func (r *RateLimiter) Close() error {
+	if r == nil {
+		return nil
 	}
+	r.closeOnce.Do(func() {
+		if r.closeCh != nil {
+			close(r.closeCh)
+		}
+	})
+	r.wg.Wait()
+	return nil
 }
 
 // Allow returns true if the request for the given key can proceed.
 func (r *RateLimiter) Allow(key string) bool {
+	allowed, _, _ := r.AllowWithHint(key)
+	return allowed
+}
+
+// AllowWithHint is Allow, additionally returning how long the caller should
+// wait before retrying (zero when allowed) and the number of requests still
+// available in the key's current burst (zero when rejected), so HTTP/gRPC
+// callers can surface a Retry-After hint instead of a bare rejection.
+func (r *RateLimiter) AllowWithHint(key string) (allowed bool, retryAfter time.Duration, remaining int) {
 	if r == nil || r.limit <= 0 {
-		return true
+		return true, 0, 0
 	}
 	if key == "" {
 		key = "missing-installation-id"
 	}
-	limiter := r.limiterForKey(key)
-	return limiter.Allow()
+	r.inflight.Add(1)
+	defer r.inflight.Add(-1)
+
+	entry := r.entryForKey(key)
+	if r.penalty.Enabled {
+		if wait, active := entry.penaltyWait(); active {
+			entry.lastSeen.Store(time.Now().UnixNano())
+			metrics.RateLimiterRejectsTotal.Inc()
+			return false, wait, 0
+		}
+	}
+
+	allowed, retryAfter, remaining = r.allowGCRA(entry)
+	entry.lastSeen.Store(time.Now().UnixNano())
+	if allowed {
+		entry.consecutiveRejections.Store(0)
+		metrics.RateLimiterAllowedTotal.Inc()
+	} else {
+		metrics.RateLimiterRejectsTotal.Inc()
+		if r.penalty.Enabled {
+			retryAfter = r.applyPenalty(entry, retryAfter)
+		}
+	}
+	return allowed, retryAfter, remaining
+}
+
+// applyPenalty escalates entry's cool-down once its consecutive rejection
+// count reaches r.penalty.Threshold: each rejection beyond the threshold
+// doubles the cool-down (BaseSeconds*2^n), capped at MaxSeconds, and stores
+// the result on entry.penaltyUntilNano so the next AllowWithHint call rejects
+// outright via penaltyWait instead of re-running the GCRA. Returns whichever
+// of gcraWait or the new cool-down is longer, so the caller's Retry-After
+// hint always matches what the next call will actually enforce.
+func (r *RateLimiter) applyPenalty(entry *rateLimiterEntry, gcraWait time.Duration) time.Duration {
+	count := entry.consecutiveRejections.Add(1)
+	threshold := int64(r.penalty.Threshold)
+	if threshold <= 0 {
+		threshold = 5
+	}
+	if count < threshold {
+		return gcraWait
+	}
+
+	base := r.penalty.BaseSeconds
+	if base <= 0 {
+		base = 1
+	}
+	maxSeconds := r.penalty.MaxSeconds
+	if maxSeconds <= 0 {
+		maxSeconds = 300
+	}
+
+	exp := count - threshold
+	if exp > 30 { // guards float64->Duration overflow from math.Pow below
+		exp = 30
+	}
+	seconds := float64(base) * math.Pow(2, float64(exp))
+	if seconds > float64(maxSeconds) {
+		seconds = float64(maxSeconds)
+	}
+
+	wait := time.Duration(seconds * float64(time.Second))
+	entry.penaltyUntilNano.Store(time.Now().Add(wait).UnixNano())
+	if wait > gcraWait {
+		return wait
+	}
+	return gcraWait
+}
+
+// allowGCRA implements the generic cell rate algorithm: for emission
+// interval T = 1/rps and burst tolerance tau = burst*T, tat' = max(tat, now)
+// + T; the request is allowed if tat' - now <= tau, otherwise it's rejected
+// with retryAfter = (tat' - now) - tau. tat is stored as the only per-key
+// state (entry.tatNano), updated via compare-and-swap so concurrent callers
+// for the same key never need a lock; a CAS that loses the race to a
+// concurrent caller for the same key simply re-reads tat and retries.
+func (r *RateLimiter) allowGCRA(entry *rateLimiterEntry) (allowed bool, retryAfter time.Duration, remaining int) {
+	rps := entry.rps * r.currentFraction()
+	if rps <= 0 {
+		return true, 0, entry.burst
+	}
+	emissionInterval := time.Duration(float64(time.Second) / rps)
+	tolerance := emissionInterval * time.Duration(entry.burst)
+
+	for {
+		now := time.Now()
+		prevTATNano := entry.tatNano.Load()
+		tat := now
+		if prevTATNano > now.UnixNano() {
+			tat = time.Unix(0, prevTATNano)
+		}
+
+		newTAT := tat.Add(emissionInterval)
+		wait := newTAT.Sub(now)
+		if wait > tolerance {
+			return false, wait - tolerance, 0
+		}
+		if !entry.tatNano.CompareAndSwap(prevTATNano, newTAT.UnixNano()) {
+			continue
+		}
+		return true, 0, int((tolerance - wait) / emissionInterval)
+	}
+}
+
+// Inflight returns the number of requests this limiter is currently
+// gating, used to drive adaptive mode's overload detection.
+func (r *RateLimiter) Inflight() int64 {
+	if r == nil {
+		return 0
+	}
+	return r.inflight.Load()
+}
+
+func (r *RateLimiter) shardFor(key string) *rateLimiterShard {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return r.shards[h.Sum32()%rateLimiterShardCount]
+}
+
+// entryForKey returns the limiter entry for key, moving it to the front of
+// its shard's LRU list. A new entry is created if none exists yet, resolving
+// rps/burst from a tenants override keyed by key if one is configured and
+// falling back to the limiter's default otherwise; if that insertion pushes
+// the shard over its MaxKeys share, the least recently used entry is evicted
+// to make room.
+func (r *RateLimiter) entryForKey(key string) *rateLimiterEntry {
+	shard := r.shardFor(key)
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if elem, ok := shard.entries[key]; ok {
+		shard.order.MoveToFront(elem)
+		return elem.Value.(*rateLimiterEntry)
+	}
+
+	rps, burst := r.limit, r.burst
+	if tenant, ok := r.tenantOverride(key); ok {
+		if tenant.RequestsPerSecond > 0 {
+			rps = tenant.RequestsPerSecond
+		}
+		if tenant.Burst > 0 {
+			burst = tenant.Burst
+		}
+	}
+	entry := &rateLimiterEntry{key: key, rps: rps, burst: burst}
+	shard.entries[key] = shard.order.PushFront(entry)
+	r.activeCount.Add(1)
+
+	if shard.order.Len() > shard.maxKeys {
+		tail := shard.order.Back()
+		evicted := shard.order.Remove(tail).(*rateLimiterEntry)
+		delete(shard.entries, evicted.key)
+		r.activeCount.Add(-1)
+		metrics.RateLimiterEvictedTotal.Inc()
+	}
+
+	metrics.RateLimiterActiveKeys.Set(float64(r.activeCount.Load()))
+	return entry
+}
+
+// tenantOverride resolves key's tenant override, preferring an exact match
+// and falling back to the longest configured tenant key that is a prefix of
+// key, so e.g. a "abuse-" entry throttles every key starting with that
+// prefix without the operator enumerating each installation ID.
+func (r *RateLimiter) tenantOverride(key string) (config.TenantRateLimitConfig, bool) {
+	if tenant, ok := r.tenants[key]; ok {
+		return tenant, true
+	}
+	var (
+		best      config.TenantRateLimitConfig
+		bestMatch string
+		found     bool
+	)
+	for prefix, tenant := range r.tenants {
+		if len(prefix) > len(bestMatch) && strings.HasPrefix(key, prefix) {
+			best, bestMatch, found = tenant, prefix, true
+		}
+	}
+	return best, found
 }
 
-func (r *RateLimiter) limiterForKey(key string) *rate.Limiter {
-	r.mu.Lock()
-	defer r.mu.Unlock()
-	if limiter, ok := r.limiters[key]; ok {
-		return limiter
+func (r *RateLimiter) janitorLoop() {
+	defer r.wg.Done()
+	ticker := time.NewTicker(r.janitorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.closeCh:
+			return
+		case <-ticker.C:
+			if r.idleTTL > 0 {
+				r.evictIdle()
+			}
+			if r.adaptive.Enabled {
+				r.adjustAdaptiveFraction()
+			}
+		}
+	}
+}
+
+// evictIdle trims each shard's idle tail: since every Allow moves its entry
+// to the front, the list is sorted by recency, so the sweep can stop at the
+// first entry that's still fresh instead of holding the shard lock while it
+// scans every key.
+func (r *RateLimiter) evictIdle() {
+	cutoff := time.Now().Add(-r.idleTTL).UnixNano()
+	for _, shard := range r.shards {
+		shard.mu.Lock()
+		for {
+			tail := shard.order.Back()
+			if tail == nil {
+				break
+			}
+			entry := tail.Value.(*rateLimiterEntry)
+			if entry.lastSeen.Load() >= cutoff {
+				break
+			}
+			shard.order.Remove(tail)
+			delete(shard.entries, entry.key)
+			r.activeCount.Add(-1)
+			metrics.RateLimiterEvictedTotal.Inc()
+		}
+		shard.mu.Unlock()
+	}
+	metrics.RateLimiterActiveKeys.Set(float64(r.activeCount.Load()))
+}
+
+// currentFraction returns the shared adaptive-mode fraction (1 when adaptive
+// mode is disabled, since adjustAdaptiveFraction never runs to change it from
+// its initial value), applied to every key's rps at check time in allowGCRA.
+func (r *RateLimiter) currentFraction() float64 {
+	r.fractionMu.Lock()
+	defer r.fractionMu.Unlock()
+	return r.fraction
+}
+
+// adjustAdaptiveFraction recomputes the shared rate fraction AIMD-style. The
+// new fraction takes effect the next time each key is checked in allowGCRA,
+// which scales entry.rps live - there's no per-entry state to update here.
+func (r *RateLimiter) adjustAdaptiveFraction() {
+	minFraction := r.adaptive.MinRateFraction
+	if minFraction <= 0 {
+		minFraction = 0.1
+	}
+	decreaseFactor := r.adaptive.DecreaseFactor
+	if decreaseFactor <= 0 || decreaseFactor >= 1 {
+		decreaseFactor = 0.5
+	}
+	recoveryStep := r.adaptive.RecoveryStep
+	if recoveryStep <= 0 {
+		recoveryStep = 0.05
+	}
+
+	r.fractionMu.Lock()
+	overloaded := r.Inflight() > r.adaptive.InflightHighWatermark
+	if overloaded {
+		r.fraction *= decreaseFactor
+		if r.fraction < minFraction {
+			r.fraction = minFraction
+		}
+	} else {
+		r.fraction += recoveryStep
+		if r.fraction > 1 {
+			r.fraction = 1
+		}
 	}
-	limiter := rate.NewLimiter(r.limit, r.burst)
-	r.limiters[key] = limiter
-	return limiter
+	r.fractionMu.Unlock()
 }
 
-// RateLimitHTTP applies rate limiting using the provided key extractor.
+// RateLimitHTTP applies rate limiting using the provided key extractor. On
+// rejection it sets Retry-After (seconds, rounded up) alongside the 429; on
+// both outcomes it sets X-RateLimit-Remaining so clients can see how much of
+// their burst is left without guessing from rejections alone.
 func RateLimitHTTP(limiter *RateLimiter, keyFn func(*http.Request) string) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			if limiter != nil && !limiter.Allow(keyFn(r)) {
-				http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
-				return
+			if limiter != nil {
+				allowed, retryAfter, remaining := limiter.AllowWithHint(keyFn(r))
+				w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+				if !allowed {
+					w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+					http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+					return
+				}
 			}
 			next.ServeHTTP(w, r)
 		})
 	}
 }
 
-// RateLimitUnary limits gRPC requests using installation IDs from telemetry packets.
+// RateLimitUnary limits gRPC requests per installationIDFromRequest key: an
+// identity an auth interceptor verified (see JWTAuthUnary) when there is one,
+// the telemetry packet's installation ID otherwise - letting fleet-wide client
+// credentials stand in for a per-device installation ID. On rejection, it
+// attaches the retry-pushback-ms trailer so SDKs that understand it can back
+// off for the hinted duration instead of retrying immediately.
 func RateLimitUnary(limiter *RateLimiter) grpc.UnaryServerInterceptor {
 	if limiter == nil || limiter.limit <= 0 {
 		return nil
 	}
 	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
-		key := installationIDFromRequest(req)
-		if !limiter.Allow(key) {
+		key := installationIDFromRequest(ctx, req)
+		allowed, retryAfter, _ := limiter.AllowWithHint(key)
+		if !allowed {
+			grpc.SetTrailer(ctx, metadata.Pairs(retryPushbackTrailer, strconv.FormatInt(retryAfter.Milliseconds(), 10)))
 			return nil, status.Error(codes.ResourceExhausted, "rate limit exceeded")
 		}
 		return handler(ctx, req)
 	}
 }
 
-func installationIDFromRequest(req any) string {
+// installationIDFromRequest prefers an identity an auth interceptor already
+// verified (mTLS CN/SPIFFE-ID SAN via PrincipalFromContext, or a bearer
+// token's customer ID/installation ID/subject claim via ClaimsFromContext,
+// in that order) over the packet's self-reported InstallationId, so a
+// client can't evade its quota by lying about its installation ID once
+// mTLS or bearer auth is enforced. CustomerID, when present, also lets
+// RateLimitConfig.Tenants key its per-tenant overrides on the authenticated
+// customer rather than a self-reported installation ID.
+func installationIDFromRequest(ctx context.Context, req any) string {
+	principal, _ := PrincipalFromContext(ctx)
+	// JWTAuthUnary sets the principal to the token's subject; when that is the
+	// principal, the token's own claims pick the key.
+	if claims, ok := ClaimsFromContext(ctx); ok && (principal == "" || principal == claims.Subject) {
+		if claims.CustomerID != "" {
+			return claims.CustomerID
+		}
+		if claims.InstallationID != "" {
+			return claims.InstallationID
+		}
+		if claims.Subject != "" {
+			return claims.Subject
+		}
+	}
+	if principal != "" {
+		return principal
+	}
 	if packet, ok := req.(*pb.TelemetryPacket); ok && packet.GetMetadata() != nil {
-		return hex.EncodeToString(packet.GetMetadata().GetInstallationId())
+		if id := packet.GetMetadata().GetInstallationId(); len(id) > 0 {
+			return hex.EncodeToString(id)
+		}
 	}
 	return ""
 }
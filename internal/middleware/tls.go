@@ -0,0 +1,66 @@
+package middleware
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/threatfabric-devops/tf-telemetry/internal/config"
+)
+
+// ServerTLSConfig builds the tls.Config httpserver.New/grpcserver.New use to
+// terminate TLS themselves. A static CertFile/KeyFile pair is preferred when
+// set; otherwise, if cfg.ACME is enabled, the returned *autocert.Manager
+// supplies certificates on demand (renewed automatically) and its
+// GetCertificate is wired onto the tls.Config - callers must also serve
+// ACMEHTTPHandler(mgr) on port 80 for the HTTP-01 challenge to succeed. The
+// manager return value is nil whenever a static certificate was used.
+func ServerTLSConfig(cfg config.TLSConfig) (*tls.Config, *autocert.Manager, error) {
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("load TLS certificate: %w", err)
+		}
+		return &tls.Config{Certificates: []tls.Certificate{cert}}, nil, nil
+	}
+	if cfg.ACME.Enabled {
+		mgr := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			Cache:      autocert.DirCache(cfg.ACME.CacheDir),
+			HostPolicy: autocert.HostWhitelist(cfg.ACME.Hosts...),
+			Email:      cfg.ACME.Email,
+		}
+		return mgr.TLSConfig(), mgr, nil
+	}
+	return nil, nil, fmt.Errorf("tls enabled but neither cert_file/key_file nor acme is configured")
+}
+
+// WithClientCA layers mTLS client-certificate requirements from mtlsCfg onto
+// base (the server's own identity, from ServerTLSConfig), returning base
+// unchanged if mtlsCfg isn't enabled. base is mutated and returned for
+// convenience.
+func WithClientCA(base *tls.Config, mtlsCfg config.MTLSConfig) (*tls.Config, error) {
+	if !mtlsCfg.Enabled {
+		return base, nil
+	}
+	clientCfg, err := ClientCATLSConfig(mtlsCfg)
+	if err != nil {
+		return nil, err
+	}
+	base.ClientCAs = clientCfg.ClientCAs
+	base.ClientAuth = clientCfg.ClientAuth
+	return base, nil
+}
+
+// ACMEHTTPHandler returns mgr's HTTP-01 challenge handler, meant to be served
+// on port 80 - ACME requires the challenge over plain HTTP on the well-known
+// port, independent of whatever port the TLS listener built from
+// ServerTLSConfig uses. Returns nil if mgr is nil.
+func ACMEHTTPHandler(mgr *autocert.Manager) http.Handler {
+	if mgr == nil {
+		return nil
+	}
+	return mgr.HTTPHandler(nil)
+}
@@ -0,0 +1,134 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/threatfabric-devops/tf-telemetry/internal/config"
+)
+
+// writeTestKeyPair generates a self-signed cert/key pair and writes them as
+// PEM files under t.TempDir(), returning their paths.
+func writeTestKeyPair(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "test-server"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create cert: %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644); err != nil {
+		t.Fatalf("write cert file: %v", err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatalf("write key file: %v", err)
+	}
+	return certFile, keyFile
+}
+
+func TestServerTLSConfig_StaticCertificate(t *testing.T) {
+	certFile, keyFile := writeTestKeyPair(t)
+
+	tlsCfg, mgr, err := ServerTLSConfig(config.TLSConfig{Enabled: true, CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if mgr != nil {
+		t.Fatal("expected no autocert manager for a static cert/key pair")
+	}
+	if len(tlsCfg.Certificates) != 1 {
+		t.Fatalf("expected one certificate to be loaded, got %d", len(tlsCfg.Certificates))
+	}
+}
+
+func TestServerTLSConfig_StaticCertificate_MissingFile(t *testing.T) {
+	_, _, err := ServerTLSConfig(config.TLSConfig{Enabled: true, CertFile: "/nonexistent/cert.pem", KeyFile: "/nonexistent/key.pem"})
+	if err == nil {
+		t.Fatal("expected an error for a missing cert file")
+	}
+}
+
+func TestServerTLSConfig_ACME(t *testing.T) {
+	tlsCfg, mgr, err := ServerTLSConfig(config.TLSConfig{
+		Enabled: true,
+		ACME: config.ACMEConfig{
+			Enabled:  true,
+			CacheDir: t.TempDir(),
+			Hosts:    []string{"telemetry.example.com"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if mgr == nil {
+		t.Fatal("expected an autocert manager when ACME is enabled")
+	}
+	if tlsCfg.GetCertificate == nil {
+		t.Fatal("expected GetCertificate to be wired from the autocert manager")
+	}
+}
+
+func TestServerTLSConfig_NeitherConfigured(t *testing.T) {
+	_, _, err := ServerTLSConfig(config.TLSConfig{Enabled: true})
+	if err == nil {
+		t.Fatal("expected an error when neither a static cert/key pair nor ACME is configured")
+	}
+}
+
+func TestWithClientCA_Disabled(t *testing.T) {
+	base := &tls.Config{}
+	got, err := WithClientCA(base, config.MTLSConfig{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != base {
+		t.Fatal("expected base to be returned unchanged when mTLS is disabled")
+	}
+}
+
+func TestWithClientCA_Enabled(t *testing.T) {
+	ca, _ := newTestCA(t)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw}), 0o644); err != nil {
+		t.Fatalf("write CA file: %v", err)
+	}
+
+	base := &tls.Config{}
+	got, err := WithClientCA(base, config.MTLSConfig{Enabled: true, ClientCAFile: caFile})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Fatalf("expected ClientAuth to require and verify client certs, got %v", got.ClientAuth)
+	}
+	if got.ClientCAs == nil {
+		t.Fatal("expected ClientCAs to be populated from the CA file")
+	}
+}
@@ -0,0 +1,221 @@
+// Package otlp translates OpenTelemetry OTLP payloads into the document shape
+// produced for ThreatFabric's native protobuf telemetry, so third-party
+// OpenTelemetry SDKs can push into the same Elasticsearch indices.
+package otlp
+
+import (
+	"encoding/hex"
+
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	logspb "go.opentelemetry.io/proto/otlp/logs/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+
+	"github.com/threatfabric-devops/tf-telemetry/internal/config"
+)
+
+// Well-known resource/scope attribute keys used to recover ThreatFabric
+// identity fields from a generic OpenTelemetry SDK's Resource attributes.
+const (
+	attrInstallationID = "tf.installation_id"
+	attrJourneyID      = "tf.journey_id"
+	attrPlatform       = "tf.platform"
+	attrCustomerID     = "tf.customer_id"
+)
+
+// identity holds the ThreatFabric fields recovered from OTLP Resource attributes.
+type identity struct {
+	customerID     string
+	installationID string
+	journeyID      string
+	platform       string
+	resource       map[string]any
+}
+
+// resolveIdentity extracts customer_id/installation_id/journey_id/platform
+// from the OTLP Resource using the well-known attribute keys plus any
+// configured aliases, and returns the remaining attributes verbatim for the
+// nested "resource" object.
+func resolveIdentity(res *resourcepb.Resource, aliases map[string]string) identity {
+	id := identity{resource: map[string]any{}}
+	if res == nil {
+		return id
+	}
+
+	keys := map[string]*string{
+		attrInstallationID: &id.installationID,
+		attrJourneyID:      &id.journeyID,
+		attrPlatform:       &id.platform,
+		attrCustomerID:     &id.customerID,
+	}
+	for field, alias := range aliases {
+		switch field {
+		case "installation_id":
+			keys[alias] = &id.installationID
+		case "journey_id":
+			keys[alias] = &id.journeyID
+		case "platform":
+			keys[alias] = &id.platform
+		case "customer_id":
+			keys[alias] = &id.customerID
+		}
+	}
+
+	for _, kv := range res.GetAttributes() {
+		if target, ok := keys[kv.GetKey()]; ok {
+			*target = attributeValueToString(kv.GetValue())
+			continue
+		}
+		id.resource[kv.GetKey()] = attributeValue(kv.GetValue())
+	}
+	return id
+}
+
+// attributeValue converts an OTLP AnyValue into a plain Go value suitable for
+// JSON indexing.
+func attributeValue(v *commonpb.AnyValue) any {
+	switch val := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return val.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return val.BoolValue
+	case *commonpb.AnyValue_IntValue:
+		return val.IntValue
+	case *commonpb.AnyValue_DoubleValue:
+		return val.DoubleValue
+	case *commonpb.AnyValue_ArrayValue:
+		items := make([]any, 0, len(val.ArrayValue.GetValues()))
+		for _, item := range val.ArrayValue.GetValues() {
+			items = append(items, attributeValue(item))
+		}
+		return items
+	case *commonpb.AnyValue_KvlistValue:
+		out := make(map[string]any, len(val.KvlistValue.GetValues()))
+		for _, kv := range val.KvlistValue.GetValues() {
+			out[kv.GetKey()] = attributeValue(kv.GetValue())
+		}
+		return out
+	case *commonpb.AnyValue_BytesValue:
+		return hex.EncodeToString(val.BytesValue)
+	default:
+		return nil
+	}
+}
+
+func attributeValueToString(v *commonpb.AnyValue) string {
+	if s, ok := attributeValue(v).(string); ok {
+		return s
+	}
+	return ""
+}
+
+// TranslateMetrics converts ResourceMetrics into the same document shape
+// produced by ingest.Sender for native MetricPoints.
+func TranslateMetrics(rms []*metricspb.ResourceMetrics, cfg config.OTLPConfig) []map[string]any {
+	var docs []map[string]any
+	for _, rm := range rms {
+		id := resolveIdentity(rm.GetResource(), cfg.AttributeAliases)
+		for _, sm := range rm.GetScopeMetrics() {
+			scope := sm.GetScope().GetName()
+			for _, metric := range sm.GetMetrics() {
+				for _, doc := range metricDocuments(metric, id, scope) {
+					docs = append(docs, doc)
+				}
+			}
+		}
+	}
+	return docs
+}
+
+func metricDocuments(metric *metricspb.Metric, id identity, scope string) []map[string]any {
+	base := func(timeUnixNano uint64) map[string]any {
+		return map[string]any{
+			"timestamp":       timeUnixNano / 1e6,
+			"customer_id":     id.customerID,
+			"platform":        id.platform,
+			"installation_id": id.installationID,
+			"journey_id":      id.journeyID,
+			"metric_name":     metric.GetName(),
+			"metric_unit":     metric.GetUnit(),
+			"scope":           scope,
+			"resource":        id.resource,
+		}
+	}
+
+	var docs []map[string]any
+	switch data := metric.GetData().(type) {
+	case *metricspb.Metric_Gauge:
+		for _, p := range data.Gauge.GetDataPoints() {
+			doc := base(p.GetTimeUnixNano())
+			doc["value"] = numberValue(p)
+			docs = append(docs, doc)
+		}
+	case *metricspb.Metric_Sum:
+		for _, p := range data.Sum.GetDataPoints() {
+			doc := base(p.GetTimeUnixNano())
+			doc["value"] = numberValue(p)
+			docs = append(docs, doc)
+		}
+	}
+	return docs
+}
+
+func numberValue(p *metricspb.NumberDataPoint) any {
+	switch v := p.GetValue().(type) {
+	case *metricspb.NumberDataPoint_AsDouble:
+		return v.AsDouble
+	case *metricspb.NumberDataPoint_AsInt:
+		return v.AsInt
+	default:
+		return nil
+	}
+}
+
+// severityLevel maps an OTLP SeverityNumber (1-24, per the OTLP logs data
+// model) to the coarse level string the rest of the pipeline expects,
+// falling back to the record's free-text SeverityText when the number is
+// UNSPECIFIED (0) or out of range.
+func severityLevel(number logspb.SeverityNumber, text string) string {
+	switch {
+	case number >= 1 && number <= 4:
+		return "trace"
+	case number >= 5 && number <= 8:
+		return "debug"
+	case number >= 9 && number <= 12:
+		return "info"
+	case number >= 13 && number <= 16:
+		return "warn"
+	case number >= 17 && number <= 20:
+		return "error"
+	case number >= 21 && number <= 24:
+		return "fatal"
+	default:
+		return text
+	}
+}
+
+// TranslateLogs converts ResourceLogs into the same document shape produced
+// by ingest.Sender for native LogEntries.
+func TranslateLogs(rls []*logspb.ResourceLogs, cfg config.OTLPConfig) []map[string]any {
+	var docs []map[string]any
+	for _, rl := range rls {
+		id := resolveIdentity(rl.GetResource(), cfg.AttributeAliases)
+		for _, sl := range rl.GetScopeLogs() {
+			scope := sl.GetScope().GetName()
+			for _, rec := range sl.GetLogRecords() {
+				docs = append(docs, map[string]any{
+					"timestamp":       rec.GetTimeUnixNano() / 1e6,
+					"customer_id":     id.customerID,
+					"platform":        id.platform,
+					"installation_id": id.installationID,
+					"journey_id":      id.journeyID,
+					"level":           severityLevel(rec.GetSeverityNumber(), rec.GetSeverityText()),
+					"scope":           scope,
+					"message":         attributeValueToString(rec.GetBody()),
+					"resource":        id.resource,
+				})
+			}
+		}
+	}
+	return docs
+}
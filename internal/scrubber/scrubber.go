@@ -0,0 +1,380 @@
+// Package scrubber redacts PII from telemetry documents before ingest hands
+// them to the bulk indexer: regex rules replace matches of common sensitive
+// value shapes (emails, IPv4/IPv6, JWTs, credit card numbers, Android
+// package names) in string fields; field-path allow/deny lists additionally
+// gate which attributes/stack_trace keys are scrubbed at all; and
+// HashFields are replaced with an HMAC-SHA256 digest instead of a fixed
+// placeholder, so a field like installation_id stays joinable across events
+// without being stored in the clear.
+package scrubber
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+
+	"github.com/threatfabric-devops/tf-telemetry/internal/config"
+)
+
+// redactedPlaceholder replaces a matched or denied value in place; unlike
+// dropping the key, it preserves the document's shape for downstream
+// mappings/dashboards that expect the field to exist.
+const redactedPlaceholder = "[REDACTED]"
+
+// scrubbedPaths are the only document subtrees field-path allow/deny rules
+// apply to; every other field is still subject to the regex/hash rules, but
+// not gated by path.
+var scrubbedPaths = []string{"attributes", "stack_trace"}
+
+// builtinRule is a regex-matched rule. validate, when set, additionally
+// filters which matches are redacted (used by the credit-card rule, whose
+// pattern alone can't distinguish a card number from any other long run of
+// digits).
+type builtinRule struct {
+	name     string
+	pattern  *regexp.Regexp
+	validate func(match string) bool
+}
+
+var (
+	emailPattern          = regexp.MustCompile(`[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}`)
+	ipv4Pattern           = regexp.MustCompile(`\b(?:(?:25[0-5]|2[0-4]\d|1?\d?\d)\.){3}(?:25[0-5]|2[0-4]\d|1?\d?\d)\b`)
+	ipv6Pattern           = regexp.MustCompile(`\b(?:[0-9a-fA-F]{1,4}:){2,7}[0-9a-fA-F]{1,4}\b`)
+	jwtPattern            = regexp.MustCompile(`\bey[A-Za-z0-9_-]{10,}\.ey[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}\b`)
+	androidPackagePattern = regexp.MustCompile(`\b[a-z][a-z0-9_]*(?:\.[a-z][a-z0-9_]*){2,}\b`)
+	// creditCardPattern matches candidate digit runs (with optional
+	// separators); luhnValid below rejects runs that aren't a real card
+	// number, e.g. ordinary IDs or phone numbers of the same length.
+	creditCardPattern = regexp.MustCompile(`\b(?:\d[ -]?){13,19}\b`)
+)
+
+// ruleSet is the immutable, ready-to-apply form of a ScrubberConfig's rules:
+// rebuilt wholesale by compile rather than mutated field-by-field, so a
+// concurrent Scrub never observes a half-updated rule set during a hot
+// reload.
+type ruleSet struct {
+	rules      []builtinRule
+	allowPaths map[string]bool
+	denyPaths  map[string]bool
+	hashFields map[string]bool
+	hmacKey    []byte
+}
+
+// Scrubber redacts PII from a telemetry document's fields. The zero value is
+// not usable; construct with New.
+type Scrubber struct {
+	set atomic.Pointer[ruleSet]
+
+	logger  *zap.Logger
+	watcher *fsnotify.Watcher
+	closeCh chan struct{}
+}
+
+// New returns a Scrubber for cfg, or nil if scrubbing is disabled. When
+// cfg.RulesFile is set, a background goroutine watches it with fsnotify and
+// hot-reloads Rules/FieldRules/HashFields on change; call Close to stop it.
+func New(logger *zap.Logger, cfg config.ScrubberConfig) (*Scrubber, error) {
+	if !cfg.Enabled {
+		return nil, nil
+	}
+	set, err := compile(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Scrubber{logger: logger}
+	s.set.Store(set)
+
+	if cfg.RulesFile == "" {
+		return s, nil
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("create scrubber rules watcher: %w", err)
+	}
+	if err := watcher.Add(filepath.Dir(cfg.RulesFile)); err != nil {
+		watcher.Close()
+		return nil, fmt.Errorf("watch scrubber rules directory: %w", err)
+	}
+	s.watcher = watcher
+	s.closeCh = make(chan struct{})
+	go s.watchLoop(cfg)
+	return s, nil
+}
+
+func (s *Scrubber) watchLoop(cfg config.ScrubberConfig) {
+	base := filepath.Base(cfg.RulesFile)
+	for {
+		select {
+		case <-s.closeCh:
+			return
+		case event, ok := <-s.watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Base(event.Name) != base {
+				continue
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+			s.reload(cfg)
+		case err, ok := <-s.watcher.Errors:
+			if !ok {
+				return
+			}
+			s.logger.Error("Scrubber rules watcher error", zap.Error(err))
+		}
+	}
+}
+
+func (s *Scrubber) reload(cfg config.ScrubberConfig) {
+	next, err := loadRulesFile(cfg)
+	if err != nil {
+		s.logger.Error("Failed to reload scrubber rules, keeping previous rules", zap.Error(err))
+		return
+	}
+	set, err := compile(next)
+	if err != nil {
+		s.logger.Error("Invalid reloaded scrubber rules, keeping previous rules", zap.Error(err))
+		return
+	}
+	s.set.Store(set)
+	s.logger.Info("Reloaded scrubber rules from disk", zap.String("path", cfg.RulesFile))
+}
+
+// loadRulesFile re-reads cfg.RulesFile and decodes its rules/field_rules/
+// hash_fields keys onto a copy of cfg. Enabled and HMACKeyHex are left as
+// cfg already had them, since a well-formed rules file doesn't set those
+// keys at all and mapstructure leaves absent-key fields untouched.
+func loadRulesFile(cfg config.ScrubberConfig) (config.ScrubberConfig, error) {
+	v := viper.New()
+	v.SetConfigFile(cfg.RulesFile)
+	if err := v.ReadInConfig(); err != nil {
+		return cfg, fmt.Errorf("read scrubber rules file: %w", err)
+	}
+	if err := v.Unmarshal(&cfg); err != nil {
+		return cfg, fmt.Errorf("decode scrubber rules file: %w", err)
+	}
+	return cfg, nil
+}
+
+// Close stops the background rules watcher, if any. Safe to call on a nil
+// Scrubber.
+func (s *Scrubber) Close() error {
+	if s == nil || s.watcher == nil {
+		return nil
+	}
+	close(s.closeCh)
+	return s.watcher.Close()
+}
+
+func compile(cfg config.ScrubberConfig) (*ruleSet, error) {
+	set := &ruleSet{
+		allowPaths: toPathSet(cfg.FieldRules.AllowPaths),
+		denyPaths:  toPathSet(cfg.FieldRules.DenyPaths),
+		hashFields: make(map[string]bool, len(cfg.HashFields)),
+	}
+	if cfg.Rules.Email {
+		set.rules = append(set.rules, builtinRule{name: "email", pattern: emailPattern})
+	}
+	if cfg.Rules.IPv4 {
+		set.rules = append(set.rules, builtinRule{name: "ipv4", pattern: ipv4Pattern})
+	}
+	if cfg.Rules.IPv6 {
+		set.rules = append(set.rules, builtinRule{name: "ipv6", pattern: ipv6Pattern})
+	}
+	if cfg.Rules.JWT {
+		set.rules = append(set.rules, builtinRule{name: "jwt", pattern: jwtPattern})
+	}
+	if cfg.Rules.CreditCard {
+		set.rules = append(set.rules, builtinRule{name: "credit_card", pattern: creditCardPattern, validate: luhnValid})
+	}
+	if cfg.Rules.AndroidPackage {
+		set.rules = append(set.rules, builtinRule{name: "android_package", pattern: androidPackagePattern})
+	}
+	for _, field := range cfg.HashFields {
+		set.hashFields[field] = true
+	}
+	if len(cfg.HashFields) > 0 {
+		key, err := hex.DecodeString(cfg.HMACKeyHex)
+		if err != nil {
+			return nil, fmt.Errorf("decode hmac_key_hex: %w", err)
+		}
+		set.hmacKey = key
+	}
+	return set, nil
+}
+
+func toPathSet(paths []string) map[string]bool {
+	if len(paths) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		set[p] = true
+	}
+	return set
+}
+
+// Scrub returns doc with PII redacted in place, then returns it for
+// convenience at call sites. Safe to call on a nil Scrubber (returns doc
+// unchanged), so callers don't need a nil check of their own.
+func (s *Scrubber) Scrub(doc map[string]any) map[string]any {
+	if s == nil {
+		return doc
+	}
+	set := s.set.Load()
+	for key, val := range doc {
+		doc[key] = s.scrubValue(set, key, val)
+	}
+	return doc
+}
+
+// scrubValue recurses into val, redacting string leaves against set's
+// regex/hash rules. path is the dotted field path from the document root,
+// used only to evaluate allow/deny once the walk is under one of
+// scrubbedPaths; outside of those subtrees every string is still scrubbed by
+// the regex/hash rules, just not subject to the path allow/deny lists.
+func (s *Scrubber) scrubValue(set *ruleSet, path string, val any) any {
+	switch v := val.(type) {
+	case string:
+		if set.hashFields[lastSegment(path)] {
+			return hashValue(set.hmacKey, v)
+		}
+		if underScrubbedPath(path) && pathDenied(set, path) {
+			return redactedPlaceholder
+		}
+		return applyRules(set, v)
+	case map[string]any:
+		out := make(map[string]any, len(v))
+		for k, nested := range v {
+			out[k] = s.scrubValue(set, joinPath(path, k), nested)
+		}
+		return out
+	case []any:
+		out := make([]any, len(v))
+		for i, nested := range v {
+			out[i] = s.scrubValue(set, path, nested)
+		}
+		return out
+	default:
+		return val
+	}
+}
+
+func lastSegment(path string) string {
+	if i := strings.LastIndexByte(path, '.'); i >= 0 {
+		return path[i+1:]
+	}
+	return path
+}
+
+func joinPath(parent, key string) string {
+	if parent == "" {
+		return key
+	}
+	return parent + "." + key
+}
+
+func underScrubbedPath(path string) bool {
+	for _, root := range scrubbedPaths {
+		if path == root || strings.HasPrefix(path, root+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// pathDenied reports whether path should be redacted wholesale: when
+// AllowPaths is configured it's a whitelist (anything not listed, and not a
+// descendant of a listed path, is denied); otherwise DenyPaths is a
+// blacklist of paths to redact on top of the regex rules.
+func pathDenied(set *ruleSet, path string) bool {
+	if len(set.allowPaths) > 0 {
+		return !pathMatches(set.allowPaths, path)
+	}
+	return pathMatches(set.denyPaths, path)
+}
+
+func pathMatches(set map[string]bool, path string) bool {
+	for p := path; p != ""; p = parentPath(p) {
+		if set[p] {
+			return true
+		}
+	}
+	return false
+}
+
+func parentPath(path string) string {
+	i := strings.LastIndexByte(path, '.')
+	if i < 0 {
+		return ""
+	}
+	return path[:i]
+}
+
+func applyRules(set *ruleSet, s string) string {
+	for _, rule := range set.rules {
+		s = rule.pattern.ReplaceAllStringFunc(s, func(match string) string {
+			if rule.validate != nil && !rule.validate(match) {
+				return match
+			}
+			return redactedPlaceholder
+		})
+	}
+	return s
+}
+
+func hashValue(key []byte, s string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(s))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// luhnValid reports whether the digits in s (separators and whitespace
+// ignored) pass the Luhn checksum real card numbers use, filtering the
+// credit-card rule's digit-run match down to plausible card numbers instead
+// of flagging every long number (order IDs, phone numbers, timestamps).
+func luhnValid(s string) bool {
+	var digits []int
+	for _, r := range s {
+		if r == ' ' || r == '-' {
+			continue
+		}
+		d, err := strconv.Atoi(string(r))
+		if err != nil {
+			return false
+		}
+		digits = append(digits, d)
+	}
+	if len(digits) < 13 || len(digits) > 19 {
+		return false
+	}
+
+	sum := 0
+	double := false
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := digits[i]
+		if double {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return sum%10 == 0
+}
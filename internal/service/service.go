@@ -4,7 +4,7 @@ package service
 import (
 	"context"
 
-	"github.com/minhtt159/tf-telemetry/internal/gen/pb"
+	"github.com/threatfabric-devops/tf-telemetry/internal/gen/pb"
 )
 
 // TelemetrySender processes telemetry packets.